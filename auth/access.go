@@ -7,12 +7,12 @@ import (
 
 func IsSlemAdmin(oktaGroups []string) (bool) {
   // Checks if the admin group is in the list of supplied groups
-  // from Okta
+  // from the auth provider
   return data.StringInSlice(oktaGroups, "shakingdog-admin-slem")
 }
 
 func IsUserAuditAdmin(oktaGroups []string) (bool) {
   // Checks if the admin group is in the list of supplied groups
-  // from Okta
+  // from the auth provider
   return data.StringInSlice(oktaGroups, "shakingdog-admin-useraudit")
 }
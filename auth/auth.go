@@ -4,56 +4,73 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"net/http"
-	"net/url"
 
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"golang.org/x/oauth2"
 )
 
-// we request these scopes from Okta
-// they will be in the returned token
-var desiredScopes = [...]string{
-	"openid",
-	"email",
-	"groups",
-}
-
 const (
 	sessionName  = "auth"
 	stateCookie  = "state"
+	nonceCookie  = "nonce"
 	userCookie   = "user"
 	groupsCookie = "groups"
+	emailCookie  = "email"
 	codeQueryKey = "code"
 
-	oktaAuthPath  = "/oauth2/v1/authorize"
-	oktaTokenPath = "/oauth2/v1/token"
+	defaultUsernameClaim = "preferred_username"
+	defaultGroupsClaim   = "groups"
 )
 
-// Okta provides methods for creating authentication handlers and wrapping
+// we request these scopes unless configured otherwise
+// they will be in the returned token
+var defaultScopes = []string{
+	"openid",
+	"email",
+	"profile",
+}
+
+// OIDC provides methods for creating authentication handlers and wrapping
 // normal handlers to ensure there is an authenticated user present.
-type Okta struct {
-	oktaDomain *url.URL
-	store      sessions.Store
-	authCfg    *oauth2.Config
+type OIDC struct {
+	provider      *Provider
+	store         sessions.Store
+	authCfg       *oauth2.Config
+	verifier      *IDTokenVerifier
+	usernameClaim string
+	groupsClaim   string
 }
 
-// NewOktaAuth creates a new authentication helper configured for Okta auth
+// NewOIDCAuth creates a new authentication helper for an OpenID Connect
+// provider
 //
-// domain, clientID and clientSecret should come straight from your Okta org and
-// the configured application.
+// clientID and clientSecret should come straight from the application
+// configured with the provider.
 // appAuthCallbackPath allow correct URLs to be built for
-// the full URL redirects to/from Okta
-func NewOktaAuth(domain, clientID, clientSecret, appAuthCallbackPath string) *Okta {
+// the full URL redirects to/from the provider
+// usernameClaim and groupsClaim name the claims holding the username and
+// the user's groups, which differ between providers
+func NewOIDCAuth(provider *Provider, clientID, clientSecret, appAuthCallbackPath string, scopes []string, usernameClaim, groupsClaim string) *OIDC {
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+	if usernameClaim == "" {
+		usernameClaim = defaultUsernameClaim
+	}
+	if groupsClaim == "" {
+		groupsClaim = defaultGroupsClaim
+	}
+
 	// generate OAuth2 config
 	authCfg := &oauth2.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
-		Scopes:       []string{"openid", "email", "profile", "groups", "address"},
+		Scopes:       scopes,
 		RedirectURL:  appAuthCallbackPath,
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  domain + oktaAuthPath,
-			TokenURL: domain + oktaTokenPath,
+			AuthURL:  provider.AuthURL,
+			TokenURL: provider.TokenURL,
 		},
 	}
 
@@ -62,15 +79,13 @@ func NewOktaAuth(domain, clientID, clientSecret, appAuthCallbackPath string) *Ok
 	signingKey := securecookie.GenerateRandomKey(32)
 	encryptKey := securecookie.GenerateRandomKey(16)
 
-	oktaBaseURL, err := url.Parse(domain)
-	if err != nil {
-		panic(err)
-	}
-
-	return &Okta{
-		oktaDomain: oktaBaseURL,
-		store:      sessions.NewCookieStore(signingKey, encryptKey),
-		authCfg:    authCfg,
+	return &OIDC{
+		provider:      provider,
+		store:         sessions.NewCookieStore(signingKey, encryptKey),
+		authCfg:       authCfg,
+		verifier:      NewIDTokenVerifier(NewKeySet(provider.JWKSURL), provider.Issuer, clientID),
+		usernameClaim: usernameClaim,
+		groupsClaim:   groupsClaim,
 	}
 }
 
@@ -82,8 +97,8 @@ func randomState() string {
 }
 
 // LoginHandler returns a handler that sends the user, with a state
-// cookie, to Okta for auth
-func (o *Okta) LoginHandler() http.Handler {
+// cookie, to the provider for auth
+func (o *OIDC) LoginHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// open the encrypted cookie-based session (it's created if it doesn't exist)
 		session, err := o.store.New(r, sessionName)
@@ -92,21 +107,28 @@ func (o *Okta) LoginHandler() http.Handler {
 			return
 		}
 
-		// generate a non-guessable value for CSRF protection
+		// generate a non-guessable value for CSRF protection, and another
+		// that ties the ID token to this login (so it can't be replayed)
 		state := randomState()
-		// store this so that the callback handler can check it later
+		nonce := randomState()
+		// store these so that the callback handler can check them later
 		session.Values[stateCookie] = state
+		session.Values[nonceCookie] = nonce
 		session.Save(r, w)
 
 		// do the redirect
-		http.Redirect(w, r, o.authCfg.AuthCodeURL(state, oauth2.AccessTypeOnline), http.StatusFound)
+		http.Redirect(w, r, o.authCfg.AuthCodeURL(
+			state,
+			oauth2.AccessTypeOnline,
+			oauth2.SetAuthURLParam("nonce", nonce),
+		), http.StatusFound)
 	})
 }
 
 // AuthCallbackHandler returns a http.Handler that will process an OAuth2
 // authentication callback. Saving the needed user information into a secure
 // cookie based session
-func (o *Okta) AuthCallbackHandler(appBaseURL string) http.Handler {
+func (o *OIDC) AuthCallbackHandler(appBaseURL string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -124,7 +146,9 @@ func (o *Okta) AuthCallbackHandler(appBaseURL string) http.Handler {
 		// check the state cookie matches the query string
 		state := r.FormValue("state")
 		wantState, ok := session.Values[stateCookie].(string)
+		nonce, _ := session.Values[nonceCookie].(string)
 		delete(session.Values, stateCookie)
+		delete(session.Values, nonceCookie)
 		if !ok || state == "" || state != wantState {
 			http.Error(w, "Incorrect state value", http.StatusBadRequest)
 			return
@@ -138,19 +162,35 @@ func (o *Okta) AuthCallbackHandler(appBaseURL string) http.Handler {
 			return
 		}
 
-		// make a client so we can get the user info and groups
-		oktaClient := NewOktaClient(o.authCfg.Client(ctx, token), o.oktaDomain)
-
-		// get the user profile
-		userInfo, _, err := oktaClient.GetOpenIDUser(ctx)
+		// the user's claims, including their groups, come from the ID token
+		// once it's verified against the provider's keys
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			http.Error(w, "Missing ID token", http.StatusInternalServerError)
+			return
+		}
+		claims, err := o.verifier.Verify(ctx, rawIDToken, nonce)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		username := claimString(claims, o.usernameClaim)
+		if username == "" {
+			username = claimString(claims, "email")
+		}
+		if username == "" {
+			http.Error(w, "Missing username claim", http.StatusInternalServerError)
 			return
 		}
 
-		// save the username and groups to the cookie
-		session.Values[userCookie] = userInfo.Username
-		session.Values[groupsCookie] = userInfo.Groups
+		// save the username and groups to the cookie, along with the email
+		// address if the provider has verified it's the user's
+		session.Values[userCookie] = username
+		session.Values[groupsCookie] = claimStrings(claims, o.groupsClaim)
+		delete(session.Values, emailCookie)
+		if verified, _ := claims["email_verified"].(bool); verified {
+			session.Values[emailCookie] = claimString(claims, "email")
+		}
 		session.Save(r, w)
 
 		// redirect back to /app
@@ -160,7 +200,7 @@ func (o *Okta) AuthCallbackHandler(appBaseURL string) http.Handler {
 
 // SecuredHandler does 2 things, ensures the user has a valid session and
 // places the user information into the request context for use within handers
-func (o *Okta) SecuredHandler(handler, needAuthHandler http.Handler) http.Handler {
+func (o *OIDC) SecuredHandler(handler, needAuthHandler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
@@ -175,6 +215,9 @@ func (o *Okta) SecuredHandler(handler, needAuthHandler http.Handler) http.Handle
 			if ok {
 				ctx = WithGroups(ctx, groups)
 			}
+			if email, ok := session.Values[emailCookie].(string); ok {
+				ctx = WithEmail(ctx, email)
+			}
 		}
 
 		if !ok {
@@ -14,6 +14,7 @@ const (
 	tokenKey key = iota
 	usernameKey
 	groupsKey
+	emailKey
 	authErrorKey
 )
 
@@ -45,6 +46,21 @@ func UsernameFromContext(ctx context.Context) string {
 	return ""
 }
 
+// WithEmail adds the user's verified email address to the context
+func WithEmail(ctx context.Context, val string) context.Context {
+	return context.WithValue(ctx, emailKey, val)
+}
+
+// EmailFromContext extracts the verified email address from the context if
+// it's present
+// Returns "" if it's not present.
+func EmailFromContext(ctx context.Context) string {
+	if val, ok := ctx.Value(emailKey).(string); ok {
+		return val
+	}
+	return ""
+}
+
 // WithError attaches auth errors to the context so a handler can inspect them
 func WithError(ctx context.Context, val error) context.Context {
 	return context.WithValue(ctx, authErrorKey, val)
@@ -65,7 +81,7 @@ func WithGroups(ctx context.Context, val []string) context.Context {
 	return context.WithValue(ctx, groupsKey, val)
 }
 
-// GroupsFromContext extracts Okta Groups from the context if it's present
+// GroupsFromContext extracts Groups from the context if it's present
 // Returns nil if it's not present.
 func GroupsFromContext(ctx context.Context) []string {
 	if groups, ok := ctx.Value(groupsKey).([]string); ok {
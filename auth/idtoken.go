@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// allowance for clocks differing between us and the provider
+const idTokenLeeway = time.Minute
+
+// ErrInvalidIDToken is returned for any ID token that fails verification
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// signature algorithms accepted, which never includes "none"
+var idTokenAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// IDTokenVerifier checks ID tokens locally against a provider's keys,
+// as an alternative to asking the provider about the user
+type IDTokenVerifier struct {
+	KeySet   *KeySet
+	Issuer   string
+	ClientID string
+	// returns the current time, replaceable for tests
+	Now      func() time.Time
+}
+
+// NewIDTokenVerifier creates a verifier for tokens issued to the client
+func NewIDTokenVerifier(keySet *KeySet, issuer, clientID string) *IDTokenVerifier {
+	return &IDTokenVerifier{
+		KeySet:   keySet,
+		Issuer:   issuer,
+		ClientID: clientID,
+		Now:      time.Now,
+	}
+}
+
+// Verify checks an ID token's signature, issuer, audience, expiry and
+// nonce, and returns its claims
+func (v *IDTokenVerifier) Verify(ctx context.Context, rawIDToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, invalidIDToken("malformed")
+	}
+
+	// check the signature
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, invalidIDToken("malformed header")
+	}
+	hash, ok := idTokenAlgorithms[header.Alg]
+	if !ok {
+		return nil, invalidIDToken(fmt.Sprintf("unsupported algorithm '%s'", header.Alg))
+	}
+	key, err := v.KeySet.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, invalidIDToken(err.Error())
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidIDToken("malformed signature")
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	err = verifySignature(header.Alg, key, hash, h.Sum(nil), signature)
+	if err != nil {
+		return nil, invalidIDToken(err.Error())
+	}
+
+	// then the claims
+	claims := map[string]interface{}{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, invalidIDToken("malformed claims")
+	}
+	if claimString(claims, "iss") != v.Issuer {
+		return nil, invalidIDToken("wrong issuer")
+	}
+	audience := claimStrings(claims, "aud")
+	if !data.StringInSlice(audience, v.ClientID) {
+		return nil, invalidIDToken("wrong audience")
+	}
+	if len(audience) > 1 && claimString(claims, "azp") != v.ClientID {
+		return nil, invalidIDToken("wrong authorized party")
+	}
+	now := v.Now()
+	expiry, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(expiry), 0).Add(idTokenLeeway)) {
+		return nil, invalidIDToken("expired")
+	}
+	if issued, ok := claims["iat"].(float64); ok && now.Add(idTokenLeeway).Before(time.Unix(int64(issued), 0)) {
+		return nil, invalidIDToken("issued in the future")
+	}
+	if nonce == "" || claimString(claims, "nonce") != nonce {
+		return nil, invalidIDToken("wrong nonce")
+	}
+	return claims, nil
+}
+
+func invalidIDToken(reason string) error {
+	return fmt.Errorf("%v: %s", ErrInvalidIDToken, reason)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, hash crypto.Hash, digest, signature []byte) error {
+	switch alg[:2] {
+	case "RS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key is not RSA")
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+	case "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key is not RSA")
+		}
+		return rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key is not EC")
+		}
+		// the signature is R and S side by side, each the size of the curve
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2 * size {
+			return fmt.Errorf("bad signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm '%s'", alg)
+}
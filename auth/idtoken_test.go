@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testClientID = "shakingdog"
+	testNonce    = "nonce-1"
+)
+
+// testProvider serves a JWKS of locally generated keys, counting fetches
+type testProvider struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	lock    sync.Mutex
+	keys    []map[string]string
+	fetches int
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProvider{rsaKey: rsaKey, ecKey: ecKey}
+	p.keys = []map[string]string{
+		rsaJWK("rsa-1", &rsaKey.PublicKey),
+		{
+			"kty": "EC",
+			"kid": "ec-1",
+			"use": "sig",
+			"crv": "P-256",
+			"x":   encodeSegment(ecKey.X.Bytes()),
+			"y":   encodeSegment(ecKey.Y.Bytes()),
+		},
+	}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		p.fetches++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": p.keys})
+	}))
+	return p
+}
+
+func (p *testProvider) Fetches() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.fetches
+}
+
+func (p *testProvider) AddKey(jwk map[string]string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.keys = append(p.keys, jwk)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   encodeSegment(key.N.Bytes()),
+		"e":   encodeSegment(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func encodeSegment(content []byte) string {
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func encodeJSONSegment(t *testing.T, v interface{}) string {
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodeSegment(content)
+}
+
+// signToken signs claims with SHA-256, as RS256 or ES256 by key type,
+// while the header claims whatever alg it's given
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	input := encodeJSONSegment(t, map[string]string{"alg": alg, "kid": kid}) + "." + encodeJSONSegment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return input + "." + encodeSegment(signature)
+}
+
+func validClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                testIssuer,
+		"aud":                testClientID,
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              testNonce,
+		"preferred_username": "someone",
+		"groups":             []string{"shakingdog-admin-slem"},
+	}
+}
+
+func TestVerifyValidToken(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	v := NewIDTokenVerifier(NewKeySet(p.server.URL), testIssuer, testClientID)
+	ctx := context.Background()
+
+	claims, err := v.Verify(ctx, signToken(t, "RS256", "rsa-1", p.rsaKey, validClaims(time.Now())), testNonce)
+	if err != nil {
+		t.Fatalf("RS256 token rejected - %v", err)
+	}
+	if claimString(claims, "preferred_username") != "someone" {
+		t.Errorf("username claim = %q", claimString(claims, "preferred_username"))
+	}
+	if groups := claimStrings(claims, "groups"); len(groups) != 1 || groups[0] != "shakingdog-admin-slem" {
+		t.Errorf("groups claim = %v", groups)
+	}
+
+	_, err = v.Verify(ctx, signToken(t, "ES256", "ec-1", p.ecKey, validClaims(time.Now())), testNonce)
+	if err != nil {
+		t.Fatalf("ES256 token rejected - %v", err)
+	}
+
+	// several audiences are fine when we're the authorized party
+	claims = validClaims(time.Now())
+	claims["aud"] = []string{testClientID, "other"}
+	claims["azp"] = testClientID
+	_, err = v.Verify(ctx, signToken(t, "RS256", "rsa-1", p.rsaKey, claims), testNonce)
+	if err != nil {
+		t.Fatalf("token with azp rejected - %v", err)
+	}
+	if p.Fetches() != 1 {
+		t.Errorf("key set fetched %d times, want 1", p.Fetches())
+	}
+}
+
+func TestVerifyRejectsClaims(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	v := NewIDTokenVerifier(NewKeySet(p.server.URL), testIssuer, testClientID)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		modify func(claims map[string]interface{})
+		nonce  string
+	}{
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://other.example.com" }, testNonce},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "other" }, testNonce},
+		{"missing azp", func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"} }, testNonce},
+		{"wrong azp", func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = "other"
+		}, testNonce},
+		{"expired", func(c map[string]interface{}) { c["exp"] = now.Add(-2 * idTokenLeeway).Unix() }, testNonce},
+		{"missing expiry", func(c map[string]interface{}) { delete(c, "exp") }, testNonce},
+		{"issued in the future", func(c map[string]interface{}) { c["iat"] = now.Add(2 * idTokenLeeway).Unix() }, testNonce},
+		{"wrong nonce", func(c map[string]interface{}) {}, "nonce-2"},
+		{"missing nonce claim", func(c map[string]interface{}) { delete(c, "nonce") }, testNonce},
+		{"no nonce expected", func(c map[string]interface{}) { c["nonce"] = "" }, ""},
+	}
+	for _, test := range tests {
+		claims := validClaims(now)
+		test.modify(claims)
+		_, err := v.Verify(context.Background(), signToken(t, "RS256", "rsa-1", p.rsaKey, claims), test.nonce)
+		if err == nil {
+			t.Errorf("%s: token accepted", test.name)
+		}
+	}
+}
+
+func TestVerifyUsesClock(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	v := NewIDTokenVerifier(NewKeySet(p.server.URL), testIssuer, testClientID)
+	issued := time.Now()
+	token := signToken(t, "RS256", "rsa-1", p.rsaKey, validClaims(issued))
+
+	// within the leeway after expiry, then beyond it
+	v.Now = func() time.Time { return issued.Add(time.Hour + idTokenLeeway/2) }
+	if _, err := v.Verify(context.Background(), token, testNonce); err != nil {
+		t.Errorf("token within leeway rejected - %v", err)
+	}
+	v.Now = func() time.Time { return issued.Add(time.Hour + 2*idTokenLeeway) }
+	if _, err := v.Verify(context.Background(), token, testNonce); err == nil {
+		t.Errorf("expired token accepted")
+	}
+
+	// before it was issued
+	v.Now = func() time.Time { return issued.Add(-2 * idTokenLeeway) }
+	if _, err := v.Verify(context.Background(), token, testNonce); err == nil {
+		t.Errorf("token issued in the future accepted")
+	}
+}
+
+func TestVerifyRejectsSignatures(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	v := NewIDTokenVerifier(NewKeySet(p.server.URL), testIssuer, testClientID)
+	ctx := context.Background()
+	claims := validClaims(time.Now())
+
+	// algorithms that don't match the key type
+	if _, err := v.Verify(ctx, signToken(t, "ES256", "rsa-1", p.rsaKey, claims), testNonce); err == nil {
+		t.Errorf("ES256 header with RSA key accepted")
+	}
+	if _, err := v.Verify(ctx, signToken(t, "RS256", "ec-1", p.ecKey, claims), testNonce); err == nil {
+		t.Errorf("RS256 header with EC key accepted")
+	}
+	if _, err := v.Verify(ctx, signToken(t, "PS256", "rsa-1", p.rsaKey, claims), testNonce); err == nil {
+		t.Errorf("PS256 header with PKCS #1 v1.5 signature accepted")
+	}
+
+	// unsigned tokens
+	unsigned := encodeJSONSegment(t, map[string]string{"alg": "none"}) + "." + encodeJSONSegment(t, claims)
+	if _, err := v.Verify(ctx, unsigned+".", testNonce); err == nil {
+		t.Errorf("alg none accepted")
+	}
+	unsigned = encodeJSONSegment(t, map[string]string{"alg": "none", "kid": "rsa-1"}) + "." + encodeJSONSegment(t, claims)
+	if _, err := v.Verify(ctx, unsigned+".", testNonce); err == nil {
+		t.Errorf("alg none with key ID accepted")
+	}
+
+	// a signature over other claims
+	token := signToken(t, "RS256", "rsa-1", p.rsaKey, claims)
+	parts := strings.Split(token, ".")
+	claims["preferred_username"] = "someone-else"
+	if _, err := v.Verify(ctx, parts[0]+"."+encodeJSONSegment(t, claims)+"."+parts[2], testNonce); err == nil {
+		t.Errorf("altered claims accepted")
+	}
+
+	// a key the provider doesn't publish
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(ctx, signToken(t, "RS256", "rsa-1", otherKey, claims), testNonce); err == nil {
+		t.Errorf("token signed by another key accepted")
+	}
+	if _, err := v.Verify(ctx, "not-a-token", testNonce); err == nil {
+		t.Errorf("malformed token accepted")
+	}
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// how long fetched keys are trusted before being fetched again
+	keySetLifetime = 24 * time.Hour
+	// least time between fetches, so that tokens with unknown key IDs
+	// can't be used to hammer the provider
+	keySetMinRefresh = time.Minute
+)
+
+// KeySet caches a provider's signing keys, as published at its JWKS URL,
+// refetching them when they expire or a token is signed by an unknown key
+type KeySet struct {
+	url     string
+	client  *http.Client
+	lock    sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// a single JSON Web Key, of which only public key fields are used
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewKeySet creates a key set for the given JWKS URL, which is not
+// fetched until a key is first needed
+func NewKeySet(url string) *KeySet {
+	return &KeySet{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+		keys:   map[string]crypto.PublicKey{},
+	}
+}
+
+// Key returns the public key with the given ID, where an empty ID is
+// only allowed if the provider publishes a single key
+func (ks *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	// a cached key is used for as long as the cache lasts
+	stale := time.Since(ks.fetched) > keySetLifetime
+	if key, ok := ks.lookup(kid); ok && !stale {
+		return key, nil
+	}
+
+	// otherwise the keys may have been rotated
+	if stale || time.Since(ks.fetched) > keySetMinRefresh {
+		err := ks.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no signing key '%s'", kid)
+}
+
+func (ks *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *KeySet) fetch(ctx context.Context) error {
+	if ks.url == "" {
+		return fmt.Errorf("provider has no JWKS URL")
+	}
+	req, err := http.NewRequest("GET", ks.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := ks.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS request failed: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return err
+	}
+
+	// keys of unknown types, or for encryption, are skipped
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	ks.keys = keys
+	ks.fetched = time.Now()
+	return nil
+}
+
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if key.N.BitLen() < 2048 || key.E < 3 {
+			return nil, fmt.Errorf("weak RSA key")
+		}
+		return key, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("EC key not on curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", jwk.Kty)
+}
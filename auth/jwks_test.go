@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+)
+
+func TestKeySetUnknownKid(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	ks := NewKeySet(p.server.URL)
+	ctx := context.Background()
+
+	if _, err := ks.Key(ctx, "rsa-1"); err != nil {
+		t.Fatalf("known key not found - %v", err)
+	}
+	if p.Fetches() != 1 {
+		t.Fatalf("key set fetched %d times, want 1", p.Fetches())
+	}
+
+	// straight after a fetch, unknown key IDs don't fetch again
+	for i := 0; i < 3; i++ {
+		if _, err := ks.Key(ctx, "rsa-2"); err == nil {
+			t.Errorf("unknown key found")
+		}
+	}
+	if p.Fetches() != 1 {
+		t.Errorf("key set fetched %d times within keySetMinRefresh, want 1", p.Fetches())
+	}
+
+	// once that's passed, an unknown key ID fetches once, picking up a
+	// rotated key
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddKey(rsaJWK("rsa-2", &rotated.PublicKey))
+	ks.fetched = time.Now().Add(-2 * keySetMinRefresh)
+	if _, err := ks.Key(ctx, "rsa-2"); err != nil {
+		t.Errorf("rotated key not found - %v", err)
+	}
+	if _, err := ks.Key(ctx, "rsa-3"); err == nil {
+		t.Errorf("unknown key found")
+	}
+	if p.Fetches() != 2 {
+		t.Errorf("key set fetched %d times, want 2", p.Fetches())
+	}
+}
+
+func TestKeySetExpiry(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	ks := NewKeySet(p.server.URL)
+	ctx := context.Background()
+
+	if _, err := ks.Key(ctx, "ec-1"); err != nil {
+		t.Fatalf("known key not found - %v", err)
+	}
+	ks.fetched = time.Now().Add(-keySetLifetime - time.Minute)
+	if _, err := ks.Key(ctx, "ec-1"); err != nil {
+		t.Fatalf("known key not found - %v", err)
+	}
+	if p.Fetches() != 2 {
+		t.Errorf("key set fetched %d times, want 2", p.Fetches())
+	}
+}
+
+func TestKeySetSkipsWeakKeys(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddKey(rsaJWK("rsa-weak", &weak.PublicKey))
+	encryption := rsaJWK("rsa-enc", &p.rsaKey.PublicKey)
+	encryption["use"] = "enc"
+	p.AddKey(encryption)
+
+	ks := NewKeySet(p.server.URL)
+	for _, kid := range []string{"rsa-weak", "rsa-enc", ""} {
+		if _, err := ks.Key(context.Background(), kid); err == nil {
+			t.Errorf("key '%s' found", kid)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+
+	oktaAuthPath  = "/oauth2/v1/authorize"
+	oktaTokenPath = "/oauth2/v1/token"
+	oktaKeysPath  = "/oauth2/v1/keys"
+)
+
+// Provider holds the endpoints of an OpenID Connect provider
+type Provider struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+// Discover fetches a provider's endpoints from its discovery document
+func Discover(ctx context.Context, issuer string) (*Provider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	req, err := http.NewRequest("GET", issuer + discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery of %s failed: %s", issuer, resp.Status)
+	}
+
+	provider := &Provider{}
+	err = json.NewDecoder(resp.Body).Decode(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	// the issuer must be exactly the one asked for, otherwise tokens
+	// from this provider won't match it
+	if strings.TrimSuffix(provider.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery of %s returned issuer %s", issuer, provider.Issuer)
+	}
+	if provider.AuthURL == "" || provider.TokenURL == "" || provider.JWKSURL == "" {
+		return nil, fmt.Errorf("discovery of %s is missing endpoints", issuer)
+	}
+	return provider, nil
+}
+
+// OktaProvider returns the endpoints of an Okta org's authorization
+// server, which are well known so need no discovery
+func OktaProvider(domain string) *Provider {
+	return &Provider{
+		Issuer:   domain,
+		AuthURL:  domain + oktaAuthPath,
+		TokenURL: domain + oktaTokenPath,
+		JWKSURL:  domain + oktaKeysPath,
+	}
+}
+
+// claimString returns a claim that is a string, or ""
+func claimString(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimStrings returns a claim that is a list of strings (or a single
+// string, as some providers send a lone group)
+func claimStrings(claims map[string]interface{}, name string) []string {
+	values := []string{}
+	switch v := claims[name].(type) {
+	case string:
+		values = append(values, v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
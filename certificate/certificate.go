@@ -0,0 +1,89 @@
+package certificate
+
+import (
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
+)
+
+// generations of ancestors shown on a certificate
+const Generations = 3
+
+// how each status is described, making clear which came from a lab
+// test and which were inferred from relatives
+var statusDescriptions = map[string]string{
+  "Affected": "Affected (lab tested)",
+  "Carrier": "Carrier (lab tested)",
+  "Clear": "Clear (lab tested)",
+  "CarrierByProgeny": "Carrier (inferred from progeny)",
+  "ClearByParentage": "Clear (inferred from parentage)",
+  "Unknown": "Unknown",
+}
+
+// an ancestor's place in the pedigree table
+type cell struct {
+  Dog *data.Dog
+  Relation string
+  Generation int
+  Row int
+  Rows int
+}
+
+
+func New(p *pedigree.Pedigree, dogId int, issued time.Time, secret []byte) *data.Certificate {
+  // builds the certificate for a dog as the register stands now, with
+  // a code that verifies for as long as the dog's statuses are unchanged
+  ancestors := p.AncestorTable(dogId, Generations)
+  return &data.Certificate{
+    Dog: *ancestors[0],
+    Ancestors: ancestors[1:],
+    Issued: issued.Format("2006-01-02"),
+    Code: Sign(secret, *ancestors[0], issued, time.Time{}),
+  }
+}
+
+func Describe(status string) string {
+  description, ok := statusDescriptions[status]
+  if !ok {
+    return status
+  }
+  return description
+}
+
+func cells(cert *data.Certificate) []cell {
+  // lays out the ancestors as a pedigree table, with parents in the
+  // first column spanning the rows of their own ancestors
+  rows := 1 << uint(Generations)
+  cells := []cell{}
+  for row := 0; row < rows; row++ {
+    for generation := 1; generation <= Generations; generation++ {
+      span := rows >> uint(generation)
+      if row % span != 0 {
+        continue
+      }
+      i := (1 << uint(generation)) - 1 + row / span
+      cells = append(cells, cell{
+        Dog: cert.Ancestors[i - 1],
+        Relation: relation(i),
+        Generation: generation,
+        Row: row,
+        Rows: span,
+      })
+    }
+  }
+  return cells
+}
+
+func relation(i int) string {
+  // describes the ancestor at index i of an ancestor table
+  own := "Dam"
+  if i % 2 == 1 {
+    own = "Sire"
+  }
+  parent := (i - 1) / 2
+  if parent == 0 {
+    return own
+  }
+  return relation(parent) + "'s " + own
+}
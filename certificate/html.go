@@ -0,0 +1,95 @@
+package certificate
+
+import (
+  "html/template"
+  "io"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+var htmlTemplate = template.Must(template.New("certificate").Funcs(template.FuncMap{
+  "describe": Describe,
+  "gender": gender,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Certificate - {{.Cert.Dog.Name}}</title>
+<style>
+  body { font-family: Helvetica, Arial, sans-serif; margin: 2em; color: #212121; }
+  .certificate { max-width: 60em; margin: auto; border: 3px double #212121; padding: 2em; }
+  h1 { text-align: center; margin: 0; }
+  h2 { text-align: center; margin: 0.2em 0 1.5em 0; font-weight: normal; }
+  table { border-collapse: collapse; width: 100%; }
+  .details td { padding: 0.3em 0.5em; }
+  .details td:first-child { font-weight: bold; width: 10em; }
+  .pedigree { margin-top: 1.5em; }
+  .pedigree td { border: 1px solid #757575; padding: 0.3em 0.5em; vertical-align: middle; width: 33%; }
+  .relation { font-size: 0.75em; color: #757575; }
+  .statuses { font-size: 0.8em; }
+  .footer { margin-top: 1.5em; display: flex; justify-content: space-between; }
+  .verify { margin-top: 0.5em; font-size: 0.8em; color: #757575; }
+  @media print { body { margin: 0; } .certificate { border-width: 2px; } }
+</style>
+</head>
+<body>
+<div class="certificate">
+  <h1>Certificate of Status</h1>
+  <h2>SLEM and CECS register</h2>
+  <table class="details">
+    <tr><td>Name</td><td>{{.Cert.Dog.Name}}</td></tr>
+    <tr><td>Gender</td><td>{{gender .Cert.Dog.Gender}}</td></tr>
+    <tr><td>SLEM status</td><td>{{describe .Cert.Dog.ShakingDogStatus}}</td></tr>
+    <tr><td>CECS status</td><td>{{describe .Cert.Dog.CecsStatus}}</td></tr>
+  </table>
+  <table class="pedigree">
+    {{- range .Rows}}
+    <tr>
+      {{- range .}}
+      <td rowspan="{{.Rows}}">
+        <div class="relation">{{.Relation}}</div>
+        {{- if .Dog}}
+        <div>{{.Dog.Name}}</div>
+        <div class="statuses">SLEM: {{describe .Dog.ShakingDogStatus}}<br>CECS: {{describe .Dog.CecsStatus}}</div>
+        {{- else}}
+        <div>Not recorded</div>
+        {{- end}}
+      </td>
+      {{- end}}
+    </tr>
+    {{- end}}
+  </table>
+  <div class="footer">
+    <div>Issued: {{.Cert.Issued}}</div>
+    <div>Verification code: <strong>{{.Cert.Code}}</strong></div>
+  </div>
+  {{- if .Cert.VerifyUrl}}
+  <div class="verify">Check this certificate is still current at <a href="{{.Cert.VerifyUrl}}">{{.Cert.VerifyUrl}}</a></div>
+  {{- end}}
+</div>
+</body>
+</html>
+`))
+
+
+func WriteHtml(w io.Writer, cert *data.Certificate) error {
+  // writes the certificate as a standalone, printable HTML page
+  rows := make([][]cell, 1 << uint(Generations))
+  for _, c := range cells(cert) {
+    rows[c.Row] = append(rows[c.Row], c)
+  }
+  return htmlTemplate.Execute(w, struct {
+    Cert *data.Certificate
+    Rows [][]cell
+  }{cert, rows})
+}
+
+func gender(gender string) string {
+  switch gender {
+  case "D":
+    return "Dog"
+  case "B":
+    return "Bitch"
+  }
+  return "Unknown"
+}
@@ -0,0 +1,179 @@
+package certificate
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// A4 page and pedigree table layout, in points
+const (
+  pdfWidth = 595
+  pdfHeight = 842
+  pdfLeft = 60
+  pdfRight = 535
+  pdfTableTop = 610
+  pdfRowHeight = 60
+  pdfColumnWidth = 160
+)
+
+// characters outside Latin-1 that have a place in WinAnsiEncoding
+var winAnsiExtras = map[rune]byte{
+  '€': 0x80,
+  '‘': 0x91,
+  '’': 0x92,
+  '“': 0x93,
+  '”': 0x94,
+  '–': 0x96,
+  '—': 0x97,
+}
+
+// a page content stream, built up with PDF drawing operators
+type pdfPage struct {
+  content bytes.Buffer
+}
+
+
+func WritePdf(w io.Writer, cert *data.Certificate) error {
+  // writes the certificate as a single page A4 PDF, using only the
+  // standard Helvetica fonts so that nothing needs to be embedded
+  page := &pdfPage{}
+  page.rect(30, 30, pdfWidth - 60, pdfHeight - 60, 2)
+  page.rect(36, 36, pdfWidth - 72, pdfHeight - 72, 0.5)
+  page.centredText(pdfWidth / 2, 770, 24, true, "Certificate of Status")
+  page.centredText(pdfWidth / 2, 745, 14, false, "SLEM and CECS register")
+
+  // dog details
+  details := [][]string{
+    {"Name", cert.Dog.Name},
+    {"Gender", gender(cert.Dog.Gender)},
+    {"SLEM status", Describe(cert.Dog.ShakingDogStatus)},
+    {"CECS status", Describe(cert.Dog.CecsStatus)},
+  }
+  for i, detail := range details {
+    y := float64(700 - i * 18)
+    page.text(pdfLeft, y, 11, true, detail[0])
+    page.text(pdfLeft + 110, y, 11, false, detail[1])
+  }
+
+  // pedigree table
+  for _, c := range cells(cert) {
+    x := float64(pdfLeft + (c.Generation - 1) * pdfColumnWidth)
+    top := float64(pdfTableTop - c.Row * pdfRowHeight)
+    height := float64(c.Rows * pdfRowHeight - 5)
+    width := float64(pdfColumnWidth - 5)
+    page.rect(x, top - height, width, height, 0.5)
+    mid := top - height / 2
+    page.greyText(x + 5, mid + 14, 7, c.Relation)
+    if c.Dog == nil {
+      page.text(x + 5, mid + 2, 9, false, "Not recorded")
+      continue
+    }
+    page.text(x + 5, mid + 2, 9, true, fitText(c.Dog.Name, width - 10, 9, true))
+    page.text(x + 5, mid - 10, 7, false, fitText("SLEM: " + Describe(c.Dog.ShakingDogStatus), width - 10, 7, false))
+    page.text(x + 5, mid - 20, 7, false, fitText("CECS: " + Describe(c.Dog.CecsStatus), width - 10, 7, false))
+  }
+
+  // footer
+  page.text(pdfLeft, 90, 11, false, "Issued: " + cert.Issued)
+  footer := "Verification code: " + cert.Code
+  page.text(pdfRight - textWidth(footer, 11, true), 90, 11, true, footer)
+  if len(cert.VerifyUrl) > 0 {
+    page.greyText(pdfLeft, 70, 8, fitText("Check this certificate is still current at " + cert.VerifyUrl, pdfRight - pdfLeft, 8, false))
+  }
+  return writePdfDocument(w, page)
+}
+
+func writePdfDocument(w io.Writer, page *pdfPage) error {
+  // writes the objects of a one page document, with the cross reference
+  // table of byte offsets that a PDF reader needs to find them
+  objects := []string{
+    "<< /Type /Catalog /Pages 2 0 R >>",
+    "<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+    fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pdfWidth, pdfHeight),
+    "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+    "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+    fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", page.content.Len(), page.content.String()),
+  }
+  var doc bytes.Buffer
+  doc.WriteString("%PDF-1.4\n")
+  offsets := []int{}
+  for i, object := range objects {
+    offsets = append(offsets, doc.Len())
+    fmt.Fprintf(&doc, "%d 0 obj\n%s\nendobj\n", i + 1, object)
+  }
+  xref := doc.Len()
+  fmt.Fprintf(&doc, "xref\n0 %d\n0000000000 65535 f \n", len(objects) + 1)
+  for _, offset := range offsets {
+    fmt.Fprintf(&doc, "%010d 00000 n \n", offset)
+  }
+  fmt.Fprintf(&doc, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects) + 1, xref)
+  _, err := w.Write(doc.Bytes())
+  return err
+}
+
+func (page *pdfPage) text(x, y float64, size int, bold bool, s string) {
+  font := "F1"
+  if bold {
+    font = "F2"
+  }
+  fmt.Fprintf(&page.content, "BT /%s %d Tf %.1f %.1f Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+func (page *pdfPage) greyText(x, y float64, size int, s string) {
+  page.content.WriteString("0.46 g\n")
+  page.text(x, y, size, false, s)
+  page.content.WriteString("0 g\n")
+}
+
+func (page *pdfPage) centredText(x, y float64, size int, bold bool, s string) {
+  page.text(x - textWidth(s, size, bold) / 2, y, size, bold, s)
+}
+
+func (page *pdfPage) rect(x, y, width, height, lineWidth float64) {
+  fmt.Fprintf(&page.content, "%.1f w %.1f %.1f %.1f %.1f re S\n", lineWidth, x, y, width, height)
+}
+
+func textWidth(s string, size int, bold bool) float64 {
+  // approximates the width of Helvetica text, which is close enough
+  // for centring and truncating
+  factor := 0.52
+  if bold {
+    factor = 0.56
+  }
+  return float64(len([]rune(s))) * float64(size) * factor
+}
+
+func fitText(s string, width float64, size int, bold bool) string {
+  // shortens text to fit the width, marking that it was shortened
+  if textWidth(s, size, bold) <= width {
+    return s
+  }
+  runes := []rune(s)
+  for len(runes) > 0 && textWidth(string(runes) + "...", size, bold) > width {
+    runes = runes[:len(runes) - 1]
+  }
+  return strings.TrimSpace(string(runes)) + "..."
+}
+
+func pdfString(s string) string {
+  // converts text to WinAnsiEncoding, escaped for a PDF string literal
+  var b bytes.Buffer
+  for _, r := range s {
+    c, ok := winAnsiExtras[r]
+    if !ok {
+      c = '?'
+      if r < 0x80 || (r >= 0xa0 && r <= 0xff) {
+        c = byte(r)
+      }
+    }
+    if c == '(' || c == ')' || c == '\\' {
+      b.WriteByte('\\')
+    }
+    b.WriteByte(c)
+  }
+  return b.String()
+}
@@ -0,0 +1,89 @@
+package certificate
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/base32"
+  "errors"
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// dates in a code, and 0 for a code that never expires
+const codeDateFormat = "20060102"
+
+// signatures are truncated to 80 bits to keep codes short enough to
+// type in from a printed certificate
+const signatureChars = 16
+
+var ErrInvalidCode = errors.New("invalid verification code")
+
+// Code is a parsed verification code, i.e.
+//   <dog id>-<issued>-<expires>-<signature>
+// where the signature is an HMAC of the dog's name and statuses as they
+// were when issued, so that it only verifies while they're unchanged
+type Code struct {
+  DogId int
+  Issued time.Time
+  Expires time.Time
+  signature string
+}
+
+
+func Sign(secret []byte, dog data.Dog, issued, expires time.Time) string {
+  // issues a code for the dog's current statuses, a zero expires means
+  // the code never expires
+  code := &Code{DogId: dog.Id, Issued: issued, Expires: expires}
+  return code.prefix() + "-" + code.expectedSignature(secret, dog)
+}
+
+func ParseCode(s string) (*Code, error) {
+  fields := strings.Split(strings.ToUpper(strings.TrimSpace(s)), "-")
+  if len(fields) != 4 {
+    return nil, ErrInvalidCode
+  }
+  dogId, err := strconv.Atoi(fields[0])
+  if err != nil {
+    return nil, ErrInvalidCode
+  }
+  issued, err := time.Parse(codeDateFormat, fields[1])
+  if err != nil {
+    return nil, ErrInvalidCode
+  }
+  code := &Code{DogId: dogId, Issued: issued, signature: fields[3]}
+  if fields[2] != "0" {
+    code.Expires, err = time.Parse(codeDateFormat, fields[2])
+    if err != nil {
+      return nil, ErrInvalidCode
+    }
+  }
+  return code, nil
+}
+
+func (c *Code) Matches(secret []byte, dog data.Dog) bool {
+  // checks the code was issued by us for the dog as it stands now
+  return hmac.Equal([]byte(c.signature), []byte(c.expectedSignature(secret, dog)))
+}
+
+func (c *Code) Expired(now time.Time) bool {
+  // a code is good up to the end of its expiry date
+  return !c.Expires.IsZero() && !now.Before(c.Expires.AddDate(0, 0, 1))
+}
+
+func (c *Code) prefix() string {
+  expires := "0"
+  if !c.Expires.IsZero() {
+    expires = c.Expires.Format(codeDateFormat)
+  }
+  return fmt.Sprintf("%d-%s-%s", c.DogId, c.Issued.Format(codeDateFormat), expires)
+}
+
+func (c *Code) expectedSignature(secret []byte, dog data.Dog) string {
+  mac := hmac.New(sha256.New, secret)
+  fmt.Fprintf(mac, "%s|%d|%s|%s|%s", c.prefix(), dog.Id, dog.Name, dog.ShakingDogStatus, dog.CecsStatus)
+  return base32.StdEncoding.EncodeToString(mac.Sum(nil))[:signatureChars]
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"bitbucket.org/Rusty1958/shakingdog/config"
+	"bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+var (
+	confFile string
+)
+
+
+func init() {
+	flag.StringVar(&confFile, "f", "", "Path to the configuration file.")
+}
+
+func main() {
+	// parse CLI arguments
+	flag.Parse()
+	if flag.NFlag() < 1 {
+		fmt.Println("== SLEM / CECS Register (Audit Verifier) ==")
+		fmt.Println()
+		flag.PrintDefaults()
+		return
+	}
+
+	// read in the config file
+	cfg, err := config.Load(confFile)
+	if err != nil {
+		log.Fatalf("ERROR: Configuration file read error - %v", err)
+	}
+	auditKey, err := cfg.AuditKey()
+	if err != nil {
+		log.Fatalf("ERROR: Configuration file read error - %v", err)
+	}
+
+	// create DB connection
+	dbConn, err := db.NewMySQLConn(
+		cfg.Server.DBHost,
+		cfg.Server.DBName,
+		cfg.Server.DBUserName,
+		cfg.Server.DBPassword,
+	)
+	if err != nil {
+		log.Fatalf("ERROR: Database connection establish error - %v", err)
+	}
+	dbConn.AuditKey = auditKey
+
+	// check the whole chain
+	report, err := db.VerifyAuditChain(dbConn)
+	if err != nil {
+		log.Fatalf("ERROR: VerifyAuditChain error - %v", err)
+	}
+	for _, chainBreak := range report.Breaks {
+		fmt.Printf("BREAK: Entry %d - %s\n", chainBreak.Id, chainBreak.Reason)
+	}
+	fmt.Printf("Checked %d entries, %d break(s)\n", report.Checked, len(report.Breaks))
+	if report.Unchained > 0 {
+		fmt.Printf("Skipped %d entries from before the chain began\n", report.Unchained)
+	}
+
+	// non-zero exit so that a scheduled check can alert
+	if !report.Valid {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bitbucket.org/Rusty1958/shakingdog/config"
+	"bitbucket.org/Rusty1958/shakingdog/data"
+	"bitbucket.org/Rusty1958/shakingdog/db"
+	"bitbucket.org/Rusty1958/shakingdog/importer"
+)
+
+var (
+	actor string
+	confFile string
+	dryRun bool
+	inFile string
+)
+
+
+func init() {
+	flag.StringVar(&confFile, "f", "", "Path to the configuration file.")
+	flag.StringVar(&inFile, "i", "", "Path to the file to import (.csv, .xlsx or .ged).")
+	flag.StringVar(&actor, "u", "Import", "Name to record against changes in the audit log.")
+	flag.BoolVar(&dryRun, "dryrun", false, "Report what would change without saving anything.")
+}
+
+func main() {
+	// parse CLI arguments
+	flag.Parse()
+	if len(confFile) == 0 || len(inFile) == 0 {
+		fmt.Println("== SLEM / CECS Register (Importer) ==")
+		fmt.Println()
+		flag.PrintDefaults()
+		return
+	}
+
+	// read in the config file
+	cfg, err := config.Load(confFile)
+	if err != nil {
+		log.Fatalf("ERROR: Configuration file read error - %v", err)
+	}
+	auditKey, err := cfg.AuditKey()
+	if err != nil {
+		log.Fatalf("ERROR: Configuration file read error - %v", err)
+	}
+
+	// read in the import file
+	content, err := ioutil.ReadFile(inFile)
+	if err != nil {
+		log.Fatalf("ERROR: Import file read error - %v", err)
+	}
+	rows, err := readRows(inFile, content)
+	if err != nil {
+		log.Fatalf("ERROR: Import file read error - %v", err)
+	}
+
+	// create DB connection
+	dbConn, err := db.NewMySQLConn(
+		cfg.Server.DBHost,
+		cfg.Server.DBName,
+		cfg.Server.DBUserName,
+		cfg.Server.DBPassword,
+	)
+	if err != nil {
+		log.Fatalf("ERROR: Database connection establish error - %v", err)
+	}
+	dbConn.AuditKey = auditKey
+
+	// everything is done in one transaction, with panic safety
+	txConn, err := dbConn.BeginReadUncommitted(nil)
+	if err != nil {
+		log.Fatalf("ERROR: Database transaction create error - %v", err)
+	}
+	defer txConn.Rollback()
+
+	report, err := importer.Import(txConn, rows, actor, dryRun)
+	if err != nil {
+		log.Fatalf("ERROR: Import error - %v", err)
+	}
+	for _, row := range report.Rows {
+		log.Printf("INFO: Line %d: %s '%s'. %s", row.Line, row.Outcome, row.Name, strings.Join(row.Messages, "; "))
+	}
+	log.Printf("INFO: %d created, %d parents created, %d updated, %d skipped, %d rejected",
+		report.Created,
+		report.ParentsCreated,
+		report.Updated,
+		report.Skipped,
+		report.Rejected,
+	)
+
+	// try commit
+	if dryRun {
+		txConn.Rollback()
+		log.Printf("INFO: Dry run, nothing was saved")
+	} else {
+		err = importer.SaveAuditSummary(
+			txConn,
+			fmt.Sprintf("file '%s'", filepath.Base(inFile)),
+			content,
+			importer.Summary(report),
+			actor,
+		)
+		if err != nil {
+			log.Fatalf("ERROR: SaveAuditSummary error - %v", err)
+		}
+		err = txConn.Commit()
+		if err != nil {
+			log.Fatalf("ERROR: Transaction commit error - %v", err)
+		}
+	}
+
+	// all done
+	os.Exit(0)
+}
+
+func readRows(path string, content []byte) ([]data.ImportRow, error) {
+	// reads import rows in the format given by the file extension
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return importer.ReadCsv(bytes.NewReader(content))
+	case ".xlsx":
+		return importer.ReadXlsx(bytes.NewReader(content), int64(len(content)))
+	case ".ged":
+		return importer.ReadGedcom(bytes.NewReader(content))
+	}
+	return nil, fmt.Errorf("unsupported file type '%s'", filepath.Ext(path))
+}
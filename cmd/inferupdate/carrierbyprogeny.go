@@ -84,6 +84,7 @@ func SetCarrierByProgeny(dog *data.Dog, logPrefix string) error {
           return err
         }
         dog.ShakingDogStatus = "CarrierByProgeny"
+        updated++
         break
       }
     }
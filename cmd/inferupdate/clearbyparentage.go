@@ -89,6 +89,7 @@ func SetClearByParentage(dog *data.Dog, logPrefix string) error {
         return err
       }
       child.ShakingDogStatus = "ClearByParentage"
+      updated++
     }
   }
 
@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"bitbucket.org/Rusty1958/shakingdog/config"
+	"bitbucket.org/Rusty1958/shakingdog/data"
 	"bitbucket.org/Rusty1958/shakingdog/db"
 )
 
@@ -16,6 +17,7 @@ var (
 	history []int
 	labConfirmedStatuses []string
 	txConn *db.Connection
+	updated int
 )
 
 
@@ -37,6 +39,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("ERROR: Configuration file read error - %v", err)
 	}
+	auditKey, err := cfg.AuditKey()
+	if err != nil {
+		log.Fatalf("ERROR: Configuration file read error - %v", err)
+	}
 
 	// create DB connection
 	dbConn, err := db.NewMySQLConn(
@@ -48,6 +54,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("ERROR: Database connection establish error - %v", err)
 	}
+	dbConn.AuditKey = auditKey
 
   // everything is done in one transaction, with panic safety
   txConn, err = dbConn.BeginReadUncommitted(nil)
@@ -86,6 +93,17 @@ func main() {
 		}
 	}
 
+	// record the run as a whole, so that it can be notified on
+	err = db.SaveAuditEntry(txConn, &data.AuditEntry{
+		Actor: "System",
+		Action: fmt.Sprintf("Inference run completed; Orphans = %d; Updated = %d", len(orphans), updated),
+		EntityType: "inference",
+		Operation: "run",
+	})
+	if err != nil {
+		log.Fatalf("ERROR: SaveAuditEntry error - %v", err)
+	}
+
   // try commit
   err = txConn.Commit()
   if err != nil {
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
@@ -9,11 +11,15 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
 	"bitbucket.org/Rusty1958/shakingdog/auth"
 	"bitbucket.org/Rusty1958/shakingdog/config"
 	"bitbucket.org/Rusty1958/shakingdog/db"
 	"bitbucket.org/Rusty1958/shakingdog/handlers"
+	"bitbucket.org/Rusty1958/shakingdog/notify"
+	"bitbucket.org/Rusty1958/shakingdog/stream"
+	"bitbucket.org/Rusty1958/shakingdog/webhook"
 	"bitbucket.org/Rusty1958/shakingdog/webserver"
 
 	"github.com/gorilla/mux"
@@ -50,6 +56,24 @@ func main() {
 	}
 	handlerContext = &handlers.Context{Config: cfg}
 
+	// without a configured secret, verification codes only last
+	// until the server is restarted
+	if len(cfg.Server.VerifySecret) == 0 {
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			log.Fatalf("Error generating verification secret - %v", err)
+		}
+		cfg.Server.VerifySecret = hex.EncodeToString(secret)
+		log.Printf("WARNING: No verifysecret configured, verification codes will not survive a restart")
+	}
+
+	// ...but the audit secret can't be made up, or the chain would break,
+	// and every write to the register would fail without it
+	auditKey, err := cfg.AuditKey()
+	if err != nil {
+		log.Fatalf("Error reading configuration file - %v", err)
+	}
+
 	// read the CA file once instead of every request
 	// get the lists of certs and keys
 	cpaths := strings.Split(cfg.Server.CertPaths, ",")
@@ -66,19 +90,34 @@ func main() {
 		log.Fatalf("Error creating web server - %v", err)
 	}
 
-	// Okta auth checker
-	handlerContext.Okta = auth.NewOktaAuth(
-		fmt.Sprintf("https://%s", cfg.Okta.Host),
-		cfg.Okta.ClientID,
-		cfg.Okta.ClientSecret,
+	// OpenID Connect auth checker, where Okta's endpoints are well
+	// known but any other provider's are discovered
+	authCfg := cfg.Auth()
+	provider := auth.OktaProvider(authCfg.Issuer)
+	if len(cfg.OIDC.Issuer) > 0 {
+		discoverCtx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+		provider, err = auth.Discover(discoverCtx, authCfg.Issuer)
+		cancel()
+		if err != nil {
+			log.Fatalf("Error discovering OpenID Connect provider - %v", err)
+		}
+	}
+	handlerContext.Auth = auth.NewOIDCAuth(
+		provider,
+		authCfg.ClientID,
+		authCfg.ClientSecret,
 		fmt.Sprintf("https://%s%s%s",
 			cfg.Server.PublicHost,
 			cfg.Server.BaseURL,
-			cfg.Okta.AuthPath,
-	))
+			authCfg.AuthPath,
+		),
+		authCfg.Scopes,
+		authCfg.UsernameClaim,
+		authCfg.GroupsClaim,
+	)
 
 	// build routes
-	s.Handler = BuildRouter(cfg, handlerContext.Okta)
+	s.Handler = BuildRouter(cfg, handlerContext.Auth)
 
 	// create DB connection
 	handlerContext.DBConn, err = db.NewMySQLConn(
@@ -90,6 +129,27 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error establishing database connection - %v", err)
 	}
+	handlerContext.DBConn.AuditKey = auditKey
+
+	// deliver webhooks in the background
+	go webhook.NewDispatcher(handlerContext.DBConn).Run(nil)
+
+	// stream register events to connected clients
+	handlerContext.Broker = stream.NewBroker(handlerContext.DBConn)
+	go handlerContext.Broker.Run(nil)
+
+	// email subscribers in the background, if there's a relay to use
+	if len(cfg.Smtp.Addr) > 0 {
+		go notify.NewNotifier(
+			handlerContext.DBConn,
+			cfg.Smtp,
+			fmt.Sprintf("https://%s%s/api/unsubscribe?token=",
+				cfg.Server.PublicHost,
+				cfg.Server.BaseURL,
+		)).Run(nil)
+	} else {
+		log.Printf("WARNING: No smtp address configured, subscribers will not be emailed")
+	}
 
 	// start listening and wait for graceful shutdown
 	// https://github.com/gorilla/mux#graceful-shutdown
@@ -109,7 +169,7 @@ func main() {
   os.Exit(0)
 }
 
-func BuildRouter(cfg *config.Config, oktaAuth *auth.Okta) http.Handler {
+func BuildRouter(cfg *config.Config, oidcAuth *auth.OIDC) http.Handler {
 	router := mux.NewRouter()
 
 	// "root" serves up static UI files
@@ -132,22 +192,137 @@ func BuildRouter(cfg *config.Config, oktaAuth *auth.Okta) http.Handler {
 		handlers.WithContext(handlerContext, handlers.DogHandler),
 	).Methods("GET")
 
+	// mate suggestions for a dog
+	router.Handle(
+		fmt.Sprintf("%s/api/dog/{id:[0-9]+}/mates", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.MatesHandler),
+	).Methods("GET")
+
+	// pedigree chart for a dog
+	router.Handle(
+		fmt.Sprintf("%s/api/dog/{id:[0-9]+}/pedigree.dot", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.PedigreeDotHandler),
+	).Methods("GET")
+	router.Handle(
+		fmt.Sprintf("%s/api/dog/{id:[0-9]+}/pedigree.svg", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.PedigreeSvgHandler),
+	).Methods("GET")
+
+	// pedigree of a dog for other registers
+	router.Handle(
+		fmt.Sprintf("%s/api/dog/{id:[0-9]+}/pedigree.ged", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.PedigreeGedcomHandler),
+	).Methods("GET")
+
+	// printable status certificate for a dog
+	router.Handle(
+		fmt.Sprintf("%s/api/dog/{id:[0-9]+}/certificate", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.CertificateHandler),
+	).Methods("GET")
+	router.Handle(
+		fmt.Sprintf("%s/api/dog/{id:[0-9]+}/certificate.pdf", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.CertificatePdfHandler),
+	).Methods("GET")
+
+	// signed link to verify a dog's current statuses
+	router.Handle(
+		fmt.Sprintf("%s/api/dog/{id:[0-9]+}/verification", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.VerificationLinkHandler),
+	).Methods("GET")
+
+	// public feeds of test results and inferred statuses
+	router.Handle(
+		fmt.Sprintf("%s/api/feed/results.atom", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.ResultsFeedHandler),
+	).Methods("GET")
+	router.Handle(
+		fmt.Sprintf("%s/api/dog/{id:[0-9]+}/results.atom", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.DogResultsFeedHandler),
+	).Methods("GET")
+
+	// public check of a verification code
+	router.Handle(
+		fmt.Sprintf("%s/api/verify", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.VerifyHandler),
+	).Methods("GET")
+
 	// family fetch
 	router.Handle(
 		fmt.Sprintf("%s/api/family", cfg.Server.BaseURL),
 		handlers.WithContext(handlerContext, handlers.FamilyHandler),
 	).Methods("GET")
 
+	// kinship between two dogs
+	router.Handle(
+		fmt.Sprintf("%s/api/kinship", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.KinshipHandler),
+	).Methods("GET")
+
+	// kinship matrix of a group of dogs
+	router.Handle(
+		fmt.Sprintf("%s/api/kinship/matrix", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.KinshipMatrixHandler),
+	).Methods("POST")
+
 	// relationships fetch
 	router.Handle(
 		fmt.Sprintf("%s/api/relationships", cfg.Server.BaseURL),
 		handlers.WithContext(handlerContext, handlers.RelationshipsHandler),
 	).Methods("GET")
 	
-	// handy Okta check
+	// allele frequency estimates
+	router.Handle(
+		fmt.Sprintf("%s/api/stats/allelefrequency", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.AlleleFrequencyHandler),
+	).Methods("GET")
+
+	// register export, with extra columns for admins
+	router.Handle(
+		fmt.Sprintf("%s/api/export/register.csv", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithContext(handlerContext, handlers.ExportCsvHandler),
+			handlers.WithContext(handlerContext, handlers.ExportCsvHandler),
+	)).Methods("GET")
+	router.Handle(
+		fmt.Sprintf("%s/api/export/register.ged", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.ExportGedcomHandler),
+	).Methods("GET")
+
+	// email subscriptions, for any logged in user
+	router.Handle(
+		fmt.Sprintf("%s/api/subscriptions", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithContext(handlerContext, handlers.SubscriptionsHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("GET")
+	router.Handle(
+		fmt.Sprintf("%s/api/subscriptions", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithContext(handlerContext, handlers.NewSubscriptionHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("POST")
+	router.Handle(
+		fmt.Sprintf("%s/api/subscriptions/{id:[0-9]+}", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithContext(handlerContext, handlers.DeleteSubscriptionHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("DELETE")
+
+	// public unsubscribe, from the link in an email, which only changes
+	// anything when confirmed
+	router.Handle(
+		fmt.Sprintf("%s/api/unsubscribe", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.UnsubscribeHandler),
+	).Methods("GET")
+	router.Handle(
+		fmt.Sprintf("%s/api/unsubscribe", cfg.Server.BaseURL),
+		handlers.WithContext(handlerContext, handlers.ConfirmUnsubscribeHandler),
+	).Methods("POST")
+
+	// handy auth check
 	router.Handle(
 		fmt.Sprintf("%s/auth", cfg.Server.BaseURL),
-		oktaAuth.SecuredHandler(
+		oidcAuth.SecuredHandler(
 			handlers.WithAdminContext(handlerContext, handlers.AuthCheckHandler),
 			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
 	)).Methods("GET")
@@ -155,23 +330,121 @@ func BuildRouter(cfg *config.Config, oktaAuth *auth.Okta) http.Handler {
 	// admin - audit
 	router.Handle(
 		fmt.Sprintf("%s/api/admin/audit", cfg.Server.BaseURL),
-		oktaAuth.SecuredHandler(
+		oidcAuth.SecuredHandler(
 			handlers.WithAdminContext(handlerContext, handlers.AuditHandler),
 			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
 	)).Methods("GET")
 
+	// admin - verify the audit hash chain
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/audit/verify", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.AuditChainHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("GET")
+
+	// admin - live stream of register events
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/events", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.StreamHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("GET")
+
+	// admin - revert an audited change
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/audit/{id:[0-9]+}/revert", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.RevertHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("POST")
+
+	// admin - webhooks
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/webhooks", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.WebhooksHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("GET")
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/webhooks", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.NewWebhookHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("POST")
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/webhooks/{id:[0-9]+}", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.DeleteWebhookHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("DELETE")
+
+	// admin - webhook delivery log
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/webhooks/{id:[0-9]+}/deliveries", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.WebhookDeliveriesHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("GET")
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/webhooks/{id:[0-9]+}/deliveries/{deliveryid:[0-9]+}/retry", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.RetryWebhookDeliveryHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("POST")
+
 	// admin - new dog
 	router.Handle(
 		fmt.Sprintf("%s/api/admin/dog", cfg.Server.BaseURL),
-		oktaAuth.SecuredHandler(
+		oidcAuth.SecuredHandler(
 			handlers.WithAdminContext(handlerContext, handlers.NewDogHandler),
 			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
 	)).Methods("POST")
 
+	// admin - change history of a dog
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/dog/{id:[0-9]+}/history", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.DogHistoryHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("GET")
+
+	// admin - CSV import
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/import/csv", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.ImportCsvHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("POST")
+
+	// admin - spreadsheet import
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/import/xlsx", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.ImportXlsxHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("POST")
+
+	// admin - GEDCOM import
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/import/gedcom", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.ImportGedcomHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("POST")
+
+	// admin - lab report import
+	router.Handle(
+		fmt.Sprintf("%s/api/admin/import/labresults", cfg.Server.BaseURL),
+		oidcAuth.SecuredHandler(
+			handlers.WithAdminContext(handlerContext, handlers.ImportLabResultsHandler),
+			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
+	)).Methods("POST")
+
 	// admin - new litter
 	router.Handle(
 		fmt.Sprintf("%s/api/admin/litter", cfg.Server.BaseURL),
-		oktaAuth.SecuredHandler(
+		oidcAuth.SecuredHandler(
 			handlers.WithAdminContext(handlerContext, handlers.NewLitterHandler),
 			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
 	)).Methods("POST")
@@ -179,7 +452,7 @@ func BuildRouter(cfg *config.Config, oktaAuth *auth.Okta) http.Handler {
 	// admin - test result
 	router.Handle(
 		fmt.Sprintf("%s/api/admin/testresult", cfg.Server.BaseURL),
-		oktaAuth.SecuredHandler(
+		oidcAuth.SecuredHandler(
 			handlers.WithAdminContext(handlerContext, handlers.TestResultHandler),
 			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
 	)).Methods("POST")
@@ -187,21 +460,21 @@ func BuildRouter(cfg *config.Config, oktaAuth *auth.Okta) http.Handler {
 	// admin - update dog
 	router.Handle(
 		fmt.Sprintf("%s/api/admin/dog", cfg.Server.BaseURL),
-		oktaAuth.SecuredHandler(
+		oidcAuth.SecuredHandler(
 			handlers.WithAdminContext(handlerContext, handlers.UpdateDogHandler),
 			handlers.WithContext(handlerContext, handlers.NeedAuthHandler),
 	)).Methods("PUT")
 
-	// sets the state cookie and bounces user to the Okta login page
+	// sets the state cookie and bounces user to the provider's login page
 	router.Handle(
-		fmt.Sprintf("%s%s", cfg.Server.BaseURL, cfg.Okta.LoginPath),
-		oktaAuth.LoginHandler(),
+		fmt.Sprintf("%s%s", cfg.Server.BaseURL, cfg.Auth().LoginPath),
+		oidcAuth.LoginHandler(),
 	).Methods("GET")
 
-	// Okta sends us back here after auth
+	// the provider sends us back here after auth
 	router.Handle(
-		fmt.Sprintf("%s%s", cfg.Server.BaseURL, cfg.Okta.AuthPath),
-		oktaAuth.AuthCallbackHandler(cfg.Server.BaseURL),
+		fmt.Sprintf("%s%s", cfg.Server.BaseURL, cfg.Auth().AuthPath),
+		oidcAuth.AuthCallbackHandler(cfg.Server.BaseURL),
 	).Methods("GET")
 
 	// unmatched redirect to "/app"
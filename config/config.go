@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 )
 
@@ -31,6 +32,15 @@ type Server struct {
 	DBName string `json:"dbname"`
 	DBUserName string `json:"dbuser"`
 	DBPassword string `json:"dbpass"`
+
+	// Secret used to sign status verification codes, changing it
+	// invalidates every code issued so far
+	VerifySecret string `json:"verifysecret"`
+
+	// Secret keying the audit hash chain, which must be kept out of the
+	// database, changing it breaks the chain at every existing entry
+	// Required, as nothing can be written to the register without it
+	AuditSecret string `json:"auditsecret"`
 }
 
 // Okta contains Okta related configuration information
@@ -47,10 +57,90 @@ type Okta struct {
 	AuthPath     string `json:"authpath"`
 }
 
+// OIDC contains the configuration for any OpenID Connect provider, used
+// instead of the Okta section when an issuer is given
+type OIDC struct {
+	// Issuer URL, under which the provider's discovery document is found
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"clientid"`
+	ClientSecret  string   `json:"clientsecret"`
+	// Relative path for login callback
+	LoginPath     string   `json:"loginpath"`
+	// Relative path for auth callback
+	AuthPath      string   `json:"authpath"`
+	// Scopes requested, which default to "openid", "email" and "profile"
+	Scopes        []string `json:"scopes"`
+	// Claims holding the username and the list of groups, which default
+	// to "preferred_username" and "groups"
+	UsernameClaim string   `json:"usernameclaim"`
+	GroupsClaim   string   `json:"groupsclaim"`
+}
+
+// Lab describes the layout of a lab's batch result report
+type Lab struct {
+	// Name used to pick the layout when uploading a report
+	Name               string `json:"name"`
+	// Header names of the report columns
+	SampleIDColumn     string `json:"sampleidcolumn"`
+	DogColumn          string `json:"dogcolumn"`
+	RegistrationColumn string `json:"registrationcolumn"`
+	AilmentColumn      string `json:"ailmentcolumn"`
+	ResultColumn       string `json:"resultcolumn"`
+	// Maps the lab's test names to "SLEM" or "CECS"
+	Ailments           map[string]string `json:"ailments"`
+	// Maps the lab's result values to register statuses
+	Results            map[string]string `json:"results"`
+}
+
+// Smtp contains the mail relay used to send notifications
+type Smtp struct {
+	// The host:port of the relay, leave empty to not send email
+	Addr     string `json:"address"`
+	// Credentials, if the relay requires them
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Sender address of every email
+	From     string `json:"from"`
+	// Minutes between batches, so that many changes (e.g. an inference
+	// run) make a single email per subscriber
+	BatchMinutes int `json:"batchminutes"`
+}
+
 // Config contains all the configuration for a callpicker2 instance.
 type Config struct {
 	Server     *Server     `json:"server"`
 	Okta       *Okta       `json:"okta"`
+	OIDC       *OIDC       `json:"oidc"`
+	Smtp       *Smtp       `json:"smtp"`
+	Labs       []*Lab      `json:"labs"`
+}
+
+// Lab returns the lab report layout with the given name, or nil
+func (c *Config) Lab(name string) *Lab {
+	for _, lab := range c.Labs {
+		if lab.Name == name {
+			return lab
+		}
+	}
+	return nil
+}
+
+// Auth returns the OpenID Connect configuration, which is built from the
+// Okta section unless an issuer is configured
+func (c *Config) Auth() *OIDC {
+	if len(c.OIDC.Issuer) > 0 {
+		return c.OIDC
+	}
+	return &OIDC{
+		Issuer:        "https://" + c.Okta.Host,
+		ClientID:      c.Okta.ClientID,
+		ClientSecret:  c.Okta.ClientSecret,
+		LoginPath:     c.Okta.LoginPath,
+		AuthPath:      c.Okta.AuthPath,
+		Scopes:        []string{"openid", "email", "profile", "groups", "address"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+	}
 }
 
 // Valid returns true if the configuration is valid
@@ -63,11 +153,22 @@ func (c *Config) Valid() bool {
 	return true
 }
 
+// AuditKey returns the secret keying the audit hash chain, which every
+// command that writes to the register needs
+func (c *Config) AuditKey() ([]byte, error) {
+	if len(c.Server.AuditSecret) == 0 {
+		return nil, errors.New("no auditsecret configured")
+	}
+	return []byte(c.Server.AuditSecret), nil
+}
+
 // New returns a freshly built config
 func New() *Config {
 	return &Config{
 		Server:     &Server{},
 		Okta:       &Okta{},
+		OIDC:       &OIDC{},
+		Smtp:       &Smtp{},
 	}
 }
 
@@ -1,15 +1,71 @@
 package data
 
+type AilmentFrequency struct {
+  Ailment string `json:"ailment"`
+  Tested int `json:"tested"`
+  Affected int `json:"affected"`
+  Carriers int `json:"carriers"`
+  Clear int `json:"clear"`
+  AlleleFrequency Estimate `json:"allelefrequency"`
+  CarrierRate Estimate `json:"carrierrate"`
+  ExpectedCarrierRate Estimate `json:"expectedcarrierrate"`
+}
+
+type AlleleFrequencyReport struct {
+  Sample string `json:"sample"`
+  Confidence float64 `json:"confidence"`
+  Ailments []AilmentFrequency `json:"ailments"`
+}
+
+type AuditChainBreak struct {
+  Id int `json:"id"`
+  Reason string `json:"reason"`
+}
+
+type AuditChainReport struct {
+  Checked int `json:"checked"`
+  Unchained int `json:"unchained"`
+  Valid bool `json:"valid"`
+  Breaks []AuditChainBreak `json:"breaks"`
+}
+
+type AuditDiff struct {
+  Before map[string]interface{} `json:"before"`
+  After map[string]interface{} `json:"after"`
+}
+
 type AuditEntry struct {
   Id int `json:"id"`
   Stamp string `json:"stamp"`
   Actor string `json:"actor"`
   Action string `json:"action"`
+  EntityType string `json:"entitytype"`
+  EntityId int `json:"entityid"`
+  Operation string `json:"operation"`
+  Diff *AuditDiff `json:"diff"`
+  RevertsId int `json:"revertsid"`
 }
 
 type AuditEntries struct {
   System []AuditEntry `json:"system"`
   User []AuditEntry `json:"user"`
+  NextSystemCursor string `json:"nextsystemcursor"`
+  NextUserCursor string `json:"nextusercursor"`
+}
+
+type Certificate struct {
+  Dog Dog `json:"dog"`
+  Ancestors []*Dog `json:"ancestors"`
+  Issued string `json:"issued"`
+  Code string `json:"code"`
+  VerifyUrl string `json:"verifyurl"`
+}
+
+type CommonAncestor struct {
+  Ancestor Dog `json:"ancestor"`
+  Generations1 int `json:"generations1"`
+  Generations2 int `json:"generations2"`
+  Paths []KinshipPath `json:"paths"`
 }
 
 type CouplesReport struct {
@@ -22,6 +78,11 @@ type Dogs struct {
   Dogs []Dog `json:"dogs"`
 }
 
+type DogHistory struct {
+  Dog Dog `json:"dog"`
+  Entries []AuditEntry `json:"entries"`
+}
+
 type DogReport struct {
   Dog Dog `json:"dog"`
   FamilyAsChild *Family `json:"familyaschild"`
@@ -37,10 +98,117 @@ type ErrorResponse struct {
   Error *ErrorMessage `json:"error"`
 }
 
+type Estimate struct {
+  Value float64 `json:"value"`
+  Lower float64 `json:"lower"`
+  Upper float64 `json:"upper"`
+}
+
+type Event struct {
+  Id int `json:"id"`
+  Type string `json:"type"`
+  Stamp string `json:"stamp"`
+  Entry AuditEntry `json:"entry"`
+}
+
 type GenericConfirm struct {
   Result string `json:"result"`
 }
 
+type ImportReport struct {
+  DryRun bool `json:"dryrun"`
+  Created int `json:"created"`
+  ParentsCreated int `json:"parentscreated"`
+  Updated int `json:"updated"`
+  Skipped int `json:"skipped"`
+  Rejected int `json:"rejected"`
+  Rows []ImportRowResult `json:"rows"`
+}
+
+type ImportRowResult struct {
+  Line int `json:"line"`
+  Name string `json:"name"`
+  Outcome string `json:"outcome"`
+  ParentsCreated int `json:"parentscreated"`
+  Messages []string `json:"messages"`
+}
+
+type InferredStatus struct {
+  Dog Dog `json:"dog"`
+  Ailment string `json:"ailment"`
+  Status string `json:"status"`
+}
+
+type KinshipGroup struct {
+  DogIds []int `json:"dogids"`
+  Depth int `json:"depth"`
+}
+
+type KinshipMatrix struct {
+  Dogs []Dog `json:"dogs"`
+  Depth int `json:"depth"`
+  Matrix [][]float64 `json:"matrix"`
+  MeanKinship []float64 `json:"meankinship"`
+}
+
+type KinshipPath struct {
+  Dogs []Dog `json:"dogs"`
+  Generations1 int `json:"generations1"`
+  Generations2 int `json:"generations2"`
+  Contribution float64 `json:"contribution"`
+}
+
+type KinshipReport struct {
+  Dog1 Dog `json:"dog1"`
+  Dog2 Dog `json:"dog2"`
+  Depth int `json:"depth"`
+  // how far back the common ancestors' lines of descent go
+  PathDepth int `json:"pathdepth"`
+  Coancestry float64 `json:"coancestry"`
+  Relationship float64 `json:"relationship"`
+  CommonAncestors []CommonAncestor `json:"commonancestors"`
+}
+
+type LabImportReport struct {
+  DryRun bool `json:"dryrun"`
+  Applied int `json:"applied"`
+  Unchanged int `json:"unchanged"`
+  Unmatched int `json:"unmatched"`
+  Ambiguous int `json:"ambiguous"`
+  Rejected int `json:"rejected"`
+  Rows []LabResultOutcome `json:"rows"`
+}
+
+type LabResultOutcome struct {
+  Line int `json:"line"`
+  SampleId string `json:"sampleid"`
+  DogName string `json:"dogname"`
+  Registration string `json:"registration"`
+  Ailment string `json:"ailment"`
+  Status string `json:"status"`
+  Outcome string `json:"outcome"`
+  Message string `json:"message"`
+  Dog *Dog `json:"dog"`
+  Candidates []Dog `json:"candidates"`
+}
+
+type LitterPreview struct {
+  Created []Dog `json:"created"`
+  Matched []Dog `json:"matched"`
+  Inferred []InferredStatus `json:"inferred"`
+  Problems []string `json:"problems"`
+}
+
+type MateCandidate struct {
+  Dog Dog `json:"dog"`
+  Kinship float64 `json:"kinship"`
+}
+
+type MateSuggestions struct {
+  Dog Dog `json:"dog"`
+  Candidates []MateCandidate `json:"candidates"`
+}
+
 type NewDog struct {
   Dog *Dog `json:"dog"`
   Sire *Dog `json:"sire"`
@@ -61,6 +229,18 @@ type Relationships struct {
   Relationships []Relationship `json:"relationships"`
 }
 
+type Subscription struct {
+  Id int `json:"id"`
+  Stamp string `json:"stamp"`
+  Email string `json:"email"`
+  Dog Dog `json:"dog"`
+  Descendants bool `json:"descendants"`
+}
+
+type Subscriptions struct {
+  Subscriptions []Subscription `json:"subscriptions"`
+}
+
 type TestResult struct {
   Dog TestResultDog `json:"dog"`
   Sire *Dog `json:"sire"` // pointer allows Nil value
@@ -83,6 +263,56 @@ type UpdateDog struct {
   Gender string `json:"gender"`
 }
 
+type Verification struct {
+  Code string `json:"code"`
+  Valid bool `json:"valid"`
+  Expired bool `json:"expired"`
+  Message string `json:"message"`
+  Dog *Dog `json:"dog"`
+  Issued string `json:"issued"`
+  Expires string `json:"expires"`
+}
+
+type VerificationLink struct {
+  Dog Dog `json:"dog"`
+  Code string `json:"code"`
+  Url string `json:"url"`
+  Issued string `json:"issued"`
+  Expires string `json:"expires"`
+}
+
+type Webhook struct {
+  Id int `json:"id"`
+  Stamp string `json:"stamp"`
+  Url string `json:"url"`
+  Events []string `json:"events"`
+  Secret string `json:"secret,omitempty"`
+  Active bool `json:"active"`
+  CreatedBy string `json:"createdby"`
+}
+
+type WebhookDeliveries struct {
+  Deliveries []WebhookDelivery `json:"deliveries"`
+  NextCursor string `json:"nextcursor"`
+}
+
+type WebhookDelivery struct {
+  Id int `json:"id"`
+  WebhookId int `json:"webhookid"`
+  EventId int `json:"eventid"`
+  EventType string `json:"eventtype"`
+  Status string `json:"status"`
+  Attempts int `json:"attempts"`
+  NextAttempt string `json:"nextattempt"`
+  LastAttempt string `json:"lastattempt"`
+  ResponseCode int `json:"responsecode"`
+  Error string `json:"error"`
+}
+
+type Webhooks struct {
+  Webhooks []Webhook `json:"webhooks"`
+}
+
 func (trd *TestResultDog) AsDataDog() (*Dog) {
   return &Dog{
     Id: trd.Id,
@@ -92,3 +322,19 @@ func (trd *TestResultDog) AsDataDog() (*Dog) {
     CecsStatus: trd.CecsStatus,
   }
 }
+
+func NewAuditDiff() *AuditDiff {
+  return &AuditDiff{
+    Before: map[string]interface{}{},
+    After: map[string]interface{}{},
+  }
+}
+
+func (diff *AuditDiff) Add(key string, before, after interface{}) {
+  // records a value only if it has changed
+  if before == after {
+    return
+  }
+  diff.Before[key] = before
+  diff.After[key] = after
+}
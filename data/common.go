@@ -28,3 +28,88 @@ type Relationship struct {
   ChildName string `json:"childname"`
   ChildShakingDogStatus string `json:"childshakingdogstatus"`
 }
+
+// a single row of the register export
+type RegisterEntry struct {
+  Dog Dog
+  SireName string
+  DamName string
+}
+
+// a single dog read from an import file
+type ImportRow struct {
+  Line int
+  Name string
+  Gender string
+  ShakingDogStatus string
+  CecsStatus string
+  SireName string
+  DamName string
+}
+
+// a single result read from a lab's batch report
+type LabResult struct {
+  Line int
+  SampleId string
+  DogName string
+  Registration string
+  Ailment string
+  Result string
+}
+
+// criteria for fetching a page of audit entries, where empty values
+// are not filtered on
+type AuditFilter struct {
+  System bool
+  From string
+  To string
+  Actor string
+  Search string
+  DogId int
+  Before int
+  Limit int
+}
+
+// criteria for fetching recent status changes, where empty values
+// are not filtered on
+type StatusChangeFilter struct {
+  DogId int
+  NamePrefix string
+  Statuses []string
+  Limit int
+}
+
+// a change to one of a dog's statuses, as recorded in the audit log
+type StatusChange struct {
+  AuditId int
+  Stamp string
+  Actor string
+  DogId int
+  DogName string
+  Ailment string
+  Before string
+  After string
+}
+
+// a webhook delivery claimed for an attempt, with what's needed to
+// make it
+type DueDelivery struct {
+  Id int
+  EventId int
+  Attempts int
+  Url string
+  Secret string
+}
+
+// a status change waiting to be emailed to a subscriber
+type Notification struct {
+  Id int
+  Email string
+  Token string
+  SubscribedName string
+  Descendants bool
+  DogName string
+  Stamp string
+  Actor string
+  Action string
+}
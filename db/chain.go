@@ -0,0 +1,165 @@
+package db
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "database/sql"
+  "fmt"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// columns covered by an entry's hash, in hashed order
+// NOTE: the stamp is hashed as seconds since the epoch rather than as
+//       text, which MySQL renders in the session's time zone
+const auditHashColumns = "UNIX_TIMESTAMP(stamp), actor, action, entitytype, entityid, operation, diff, revertsid"
+const auditHashColumnCount = 8
+
+
+func AuditHash(key []byte, prevHash string, values []sql.NullString) string {
+  // keys a hash of an entry's column values together with the previous
+  // entry's hash, so that changing, removing or reordering any entry
+  // changes the hash of every entry after it, and so that the hashes
+  // can't be recomputed without the key
+  mac := hmac.New(sha256.New, key)
+  mac.Write([]byte(prevHash))
+  for _, value := range values {
+    mac.Write([]byte("|" + value.String))
+  }
+  return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+func _LockAuditHead(dbConn *Connection) (string, error) {
+  // locks the head of the chain until the transaction ends, so that
+  // concurrent entries are chained one after the other
+  var lastHash string
+  err := dbConn.QueryRow(`
+    SELECT lasthash
+    FROM audithead
+    WHERE id = 1
+    FOR UPDATE`,
+  ).Scan(&lastHash)
+  return lastHash, err
+}
+
+func _MoveAuditHead(dbConn *Connection, id int64, hash string) error {
+  // moves the head of the chain to a newly saved entry
+  _, err := dbConn.Exec(`
+    UPDATE audithead
+    SET lastid = ?, lasthash = ?
+    WHERE id = 1`,
+    id,
+    hash,
+  )
+  return err
+}
+
+func _NullIntString(value sql.NullInt64) sql.NullString {
+  // the text the database gives back for a nullable integer column
+  if !value.Valid {
+    return sql.NullString{}
+  }
+  return sql.NullString{String: strconv.FormatInt(value.Int64, 10), Valid: true}
+}
+
+func VerifyAuditChain(dbConn *Connection) (*data.AuditChainReport, error) {
+  // recomputes the hash of every audit entry, oldest first, reporting
+  // each entry that does not follow on from the one before it
+  // NOTE: entries from before the chain began have no hash, and are
+  //       counted but can't be checked
+  if len(dbConn.AuditKey) == 0 {
+    return nil, ErrNoAuditKey
+  }
+  report := &data.AuditChainReport{
+    Breaks: []data.AuditChainBreak{},
+  }
+  rows, err := dbConn.Query(`
+    SELECT id, ` + auditHashColumns + `, prevhash, hash
+    FROM audit
+    ORDER BY id`,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  // check each entry against the one before
+  values := make([]sql.NullString, auditHashColumnCount + 3)
+  dest := make([]interface{}, len(values))
+  for i, _ := range values {
+    dest[i] = &values[i]
+  }
+  chained := false
+  prevHash := ""
+  lastId := 0
+  for rows.Next() {
+    err = rows.Scan(dest...)
+    if err != nil {
+      return nil, err
+    }
+    lastId, err = strconv.Atoi(values[0].String)
+    if err != nil {
+      return nil, err
+    }
+    storedPrevHash, storedHash := values[auditHashColumnCount + 1], values[auditHashColumnCount + 2]
+    if !storedHash.Valid && !chained {
+      report.Unchained++
+      continue
+    }
+    chained = true
+    report.Checked++
+    if !storedHash.Valid {
+      report.Breaks = append(report.Breaks, data.AuditChainBreak{
+        Id: lastId,
+        Reason: "Entry is not chained",
+      })
+    } else {
+      if storedPrevHash.String != prevHash {
+        report.Breaks = append(report.Breaks, data.AuditChainBreak{
+          Id: lastId,
+          Reason: "Previous hash does not match the entry before (an entry has been removed or altered)",
+        })
+      }
+      if AuditHash(dbConn.AuditKey, storedPrevHash.String, values[1:auditHashColumnCount + 1]) != storedHash.String {
+        report.Breaks = append(report.Breaks, data.AuditChainBreak{
+          Id: lastId,
+          Reason: "Hash does not match content (the entry has been altered)",
+        })
+      }
+    }
+    prevHash = storedHash.String
+  }
+  err = rows.Err()
+  if err != nil {
+    return nil, err
+  }
+
+  // the head catches entries removed from the end of the chain
+  var headId int
+  var headHash string
+  err = dbConn.QueryRow(`
+    SELECT lastid, lasthash
+    FROM audithead
+    WHERE id = 1`,
+  ).Scan(&headId, &headHash)
+  if err != nil {
+    return nil, err
+  }
+  if !chained {
+    lastId = 0
+  }
+  if headId != lastId {
+    report.Breaks = append(report.Breaks, data.AuditChainBreak{
+      Id: headId,
+      Reason: fmt.Sprintf("Chain ends at entry %d but the last entry is %d (entries have been removed)", headId, lastId),
+    })
+  } else if headHash != prevHash {
+    report.Breaks = append(report.Breaks, data.AuditChainBreak{
+      Id: headId,
+      Reason: "Hash does not match the end of the chain (the entry has been altered)",
+    })
+  }
+  report.Valid = len(report.Breaks) == 0
+  return report, nil
+}
@@ -20,10 +20,13 @@ const (
 type Connection struct {
   Conn *sql.DB
   Tx *sql.Tx
+  // secret keying the audit hash chain, kept out of the database so
+  // that the chain can't be recomputed by anyone with DB access alone
+  AuditKey []byte
 }
 
 func NewConnection(conn *sql.DB, tx *sql.Tx) *Connection {
-  return &Connection{conn, tx}
+  return &Connection{conn, tx, nil}
 }
 
 func (dbc *Connection) Begin(ctx context.Context, txOpts *sql.TxOptions) (*Connection, error) {
@@ -35,7 +38,7 @@ func (dbc *Connection) Begin(ctx context.Context, txOpts *sql.TxOptions) (*Conne
     if err != nil {
       return nil, err
     }
-    return &Connection{dbc.Conn, tx}, nil
+    return &Connection{dbc.Conn, tx, dbc.AuditKey}, nil
   }
 
   // ...with context and options
@@ -43,7 +46,7 @@ func (dbc *Connection) Begin(ctx context.Context, txOpts *sql.TxOptions) (*Conne
   if err != nil {
     return nil, err
   }
-  return &Connection{dbc.Conn, tx}, nil
+  return &Connection{dbc.Conn, tx, dbc.AuditKey}, nil
 }
 
 func (dbc *Connection) BeginReadUncommitted(ctx context.Context) (*Connection, error) {
@@ -105,6 +108,18 @@ func (dbc *Connection) Rollback() {
   }
 }
 
+func (dbc *Connection) Savepoint(name string) error {
+  // marks a point in the transaction that can be rolled back to
+  _, err := dbc.Exec(fmt.Sprintf("SAVEPOINT %s", name))
+  return err
+}
+
+func (dbc *Connection) RollbackTo(name string) error {
+  // undoes everything in the transaction since the named savepoint
+  _, err := dbc.Exec(fmt.Sprintf("ROLLBACK TO SAVEPOINT %s", name))
+  return err
+}
+
 func NewMySQLConn(host, database, user, pass string) (*Connection, error) {
   // returns a new SQL connection pool controller
   connectionString := fmt.Sprintf(
@@ -7,6 +7,9 @@ import (
 )
 
 var ErrUniqueViolation = errors.New("db: unique constraint violation")
+var ErrNotRevertible = errors.New("db: audit entry cannot be reverted")
+var ErrRevertConflict = errors.New("db: record has changed since audit entry")
+var ErrNoAuditKey = errors.New("db: no audit key configured")
 
 
 func TranslateError(err error) error {
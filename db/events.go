@@ -0,0 +1,161 @@
+package db
+
+import (
+  "database/sql"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+const (
+  EventDogCreated = "dog.created"
+  EventDogUpdated = "dog.updated"
+  EventStatusChanged = "dog.statuschanged"
+  EventParentageChanged = "dog.parentagechanged"
+  EventInferenceCompleted = "inference.completed"
+)
+
+var EventTypes = []string{
+  EventDogCreated,
+  EventDogUpdated,
+  EventStatusChanged,
+  EventParentageChanged,
+  EventInferenceCompleted,
+}
+
+
+func EventType(entry *data.AuditEntry) string {
+  // the event an audit entry is notified as, if any
+  switch entry.EntityType + " " + entry.Operation {
+  case "dog create":
+    return EventDogCreated
+  case "dog update":
+    if entry.Diff == nil {
+      return ""
+    }
+    _, slem := entry.Diff.After["shakingdogstatus"]
+    _, cecs := entry.Diff.After["cecsstatus"]
+    if slem || cecs {
+      return EventStatusChanged
+    }
+    return EventDogUpdated
+  case "relationship create", "relationship update", "relationship delete":
+    return EventParentageChanged
+  case "inference run":
+    return EventInferenceCompleted
+  }
+  return ""
+}
+
+func _SaveEvent(dbConn *Connection, auditId int64, entry *data.AuditEntry) error {
+  // records the event for an audit entry and queues a delivery to
+  // every active webhook that wants it, and any emails for it
+  eventType := EventType(entry)
+  if len(eventType) == 0 {
+    return nil
+  }
+  result, err := dbConn.Exec(`
+    INSERT INTO event (type, auditid)
+    VALUES (?, ?)`,
+    eventType,
+    auditId,
+  )
+  if err != nil {
+    return err
+  }
+  eventId, err := result.LastInsertId()
+  if err != nil {
+    return err
+  }
+  if eventType == EventStatusChanged {
+    err = _SaveNotifications(dbConn, eventId, entry.EntityId)
+    if err != nil {
+      return err
+    }
+  }
+  _, err = dbConn.Exec(`
+    INSERT INTO webhookdelivery (webhookid, eventid, status, attempts, nextattempt)
+    SELECT id, ?, 'pending', 0, CURRENT_TIMESTAMP
+    FROM webhook
+    WHERE active AND FIND_IN_SET(?, events)`,
+    eventId,
+    eventType,
+  )
+  return err
+}
+
+func GetEvent(dbConn *Connection, id int) (data.Event, error) {
+  // fetches an event along with the audit entry it was raised for
+  var event data.Event
+  var auditId int
+  err := dbConn.QueryRow(`
+    SELECT id, stamp, type, auditid
+    FROM event
+    WHERE id = ?`,
+    id,
+  ).Scan(&event.Id, &event.Stamp, &event.Type, &auditId)
+  if err != nil {
+    return event, err
+  }
+  event.Entry, err = GetAuditEntry(dbConn, auditId)
+  return event, err
+}
+
+func GetLastEventId(dbConn *Connection) (int, error) {
+  var id int
+  err := dbConn.QueryRow(`
+    SELECT IFNULL(MAX(id), 0)
+    FROM event`,
+  ).Scan(&id)
+  return id, err
+}
+
+func GetEventsSince(dbConn *Connection, afterId, limit int) ([]data.Event, error) {
+  // fetches the events after a given one, oldest first, along with the
+  // audit entries they were raised for
+  rows, err := dbConn.Query(`
+    SELECT e.id, e.stamp, e.type, a.id, a.stamp, a.actor, a.action, a.entitytype, a.entityid, a.operation, a.diff, a.revertsid
+    FROM event e
+    JOIN audit a
+      ON e.auditid = a.id
+    WHERE e.id > ?
+    ORDER BY e.id
+    LIMIT ?`,
+    afterId,
+    limit,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  events := []data.Event{}
+  for rows.Next() {
+    var event data.Event
+    var entityType, operation, diff sql.NullString
+    var entityId, revertsId sql.NullInt64
+    err = rows.Scan(
+      &event.Id,
+      &event.Stamp,
+      &event.Type,
+      &event.Entry.Id,
+      &event.Entry.Stamp,
+      &event.Entry.Actor,
+      &event.Entry.Action,
+      &entityType,
+      &entityId,
+      &operation,
+      &diff,
+      &revertsId,
+    )
+    if err != nil {
+      return nil, err
+    }
+    err = _FillAuditEntry(&event.Entry, entityType, entityId, operation, diff, revertsId)
+    if err != nil {
+      return nil, err
+    }
+    events = append(events, event)
+  }
+  return events, rows.Err()
+}
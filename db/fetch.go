@@ -2,6 +2,8 @@ package db
 
 import (
   "database/sql"
+  "encoding/json"
+  "strings"
 
   "bitbucket.org/Rusty1958/shakingdog/data"
 )
@@ -13,20 +15,47 @@ func _AuditEntriesFromRows(rows *sql.Rows) ([]data.AuditEntry, error) {
   entries := []data.AuditEntry{}
   for rows.Next() {
     var entry data.AuditEntry
+    var entityType, operation, diff sql.NullString
+    var entityId, revertsId sql.NullInt64
     err := rows.Scan(
       &entry.Id,
       &entry.Stamp,
       &entry.Actor,
       &entry.Action,
+      &entityType,
+      &entityId,
+      &operation,
+      &diff,
+      &revertsId,
     )
     if err != nil {
       return nil, err
     }
+    err = _FillAuditEntry(&entry, entityType, entityId, operation, diff, revertsId)
+    if err != nil {
+      return nil, err
+    }
     entries = append(entries, entry)
   }
   return entries, nil
 }
 
+func _FillAuditEntry(entry *data.AuditEntry, entityType sql.NullString, entityId sql.NullInt64, operation, diff sql.NullString, revertsId sql.NullInt64) error {
+  // utility function that sets the optional fields of an AuditEntry
+  entry.EntityType = entityType.String
+  entry.EntityId = int(entityId.Int64)
+  entry.RevertsId = int(revertsId.Int64)
+  entry.Operation = operation.String
+  if diff.Valid {
+    // numbers are kept as written, rather than as float64
+    entry.Diff = &data.AuditDiff{}
+    decoder := json.NewDecoder(strings.NewReader(diff.String))
+    decoder.UseNumber()
+    return decoder.Decode(entry.Diff)
+  }
+  return nil
+}
+
 func _DogsFromRows(rows *sql.Rows) ([]data.Dog, error) {
   // utility function that constructs a list of Dog
   // objects from the results of a SQL query
@@ -50,19 +79,62 @@ func _DogsFromRows(rows *sql.Rows) ([]data.Dog, error) {
   return dogs, nil
 }
 
-func GetSystemAuditEntries(dbConn *Connection) ([]data.AuditEntry, error) {
-  // fetches all audit entries generated by the system
+func EscapeLike(s string) string {
+  // escapes the LIKE wildcards in a string so they match literally
+  return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
+func GetAuditEntries(dbConn *Connection, filter *data.AuditFilter) ([]data.AuditEntry, error) {
+  // fetches a page of audit entries, newest first, generated by either
+  // the system or by users and matching the filter
+  // NOTE: paging is by ID rather than offset so that a page is not
+  //       shifted by entries written while paging
+  conditions := []string{"actor <> 'System'"}
+  args := []interface{}{}
+  if filter.System {
+    conditions = []string{"actor = 'System'"}
+  }
+  if len(filter.From) > 0 {
+    conditions = append(conditions, "stamp >= ?")
+    args = append(args, filter.From)
+  }
+  if len(filter.To) > 0 {
+    conditions = append(conditions, "stamp < ?")
+    args = append(args, filter.To)
+  }
+  if len(filter.Actor) > 0 {
+    conditions = append(conditions, "actor = ?")
+    args = append(args, filter.Actor)
+  }
+  if len(filter.Search) > 0 {
+    // matches the text anywhere in the action, which no index can help
+    // with, so entries are read newest first within the other conditions
+    // until a page is found
+    conditions = append(conditions, "action LIKE ?")
+    args = append(args, "%" + EscapeLike(filter.Search) + "%")
+  }
+  if filter.DogId > 0 {
+    conditions = append(conditions, "entitytype IN ('dog', 'relationship') AND entityid = ?")
+    args = append(args, filter.DogId)
+  }
+  if filter.Before > 0 {
+    conditions = append(conditions, "id < ?")
+    args = append(args, filter.Before)
+  }
+  args = append(args, filter.Limit)
   rows, err := dbConn.Query(`
-    SELECT id, stamp, actor, action
+    SELECT id, stamp, actor, action, entitytype, entityid, operation, diff, revertsid
     FROM audit
-    WHERE actor = 'System'
-    ORDER BY stamp DESC`,
+    WHERE ` + strings.Join(conditions, " AND ") + `
+    ORDER BY id DESC
+    LIMIT ?`,
+    args...,
   )
   if err != nil {
     return nil, err
   }
   defer rows.Close()
-  
+
   // parse result(s)
   entries, err := _AuditEntriesFromRows(rows)
   if err != nil {
@@ -71,19 +143,52 @@ func GetSystemAuditEntries(dbConn *Connection) ([]data.AuditEntry, error) {
   return entries, nil
 }
 
-func GetUserAuditEntries(dbConn *Connection) ([]data.AuditEntry, error) {
-  // fetches all audit entries generated by users
+func GetAuditEntry(dbConn *Connection, id int) (data.AuditEntry, error) {
+  // fetches an individual audit entry
   rows, err := dbConn.Query(`
-    SELECT id, stamp, actor, action
+    SELECT id, stamp, actor, action, entitytype, entityid, operation, diff, revertsid
     FROM audit
-    WHERE actor <> 'System'
-    ORDER BY stamp DESC`,
+    WHERE id = ?`,
+    id,
+  )
+  if err != nil {
+    return data.AuditEntry{}, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  entries, err := _AuditEntriesFromRows(rows)
+  if err != nil {
+    return data.AuditEntry{}, err
+  }
+  if len(entries) == 0 {
+    return data.AuditEntry{}, sql.ErrNoRows
+  }
+  return entries[0], nil
+}
+
+func GetDogAuditEntries(dbConn *Connection, dogId int) ([]data.AuditEntry, error) {
+  // fetches every audit entry for changes to a dog, to its parents,
+  // and to the relationships it's a parent in, oldest first
+  // NOTE: it's only known that a dog was a parent from the ids in the
+  //       diff, which entries from before the diff was recorded don't
+  //       have, so those are only found for the child
+  rows, err := dbConn.Query(`
+    SELECT id, stamp, actor, action, entitytype, entityid, operation, diff, revertsid
+    FROM audit
+    WHERE (entitytype IN ('dog', 'relationship') AND entityid = ?)
+      OR (entitytype = 'relationship' AND JSON_CONTAINS(
+        JSON_EXTRACT(diff, '$.before.sireid', '$.after.sireid', '$.before.damid', '$.after.damid'),
+        CAST(? AS JSON)))
+    ORDER BY id`,
+    dogId,
+    dogId,
   )
   if err != nil {
     return nil, err
   }
   defer rows.Close()
-  
+
   // parse result(s)
   entries, err := _AuditEntriesFromRows(rows)
   if err != nil {
@@ -488,3 +593,78 @@ func GetFamilies(dbConn *Connection, dogId int) (*data.Family, []data.Family, er
   }
   return &familyAsChild, familiesAsParent, nil
 }
+
+func StreamRegister(dbConn *Connection, handler func(entry *data.RegisterEntry) error) error {
+  // passes each dog, with its parents' names, to a handler one at a
+  // time so the register never has to be held in memory
+  rows, err := dbConn.Query(`
+    SELECT d.id, d.name, d.gender, s1.status, s2.status, d.shakingdoginferoverride, d.cecsinferoverride,
+           COALESCE(sire.name, ''), COALESCE(dam.name, '')
+    FROM dog d
+    JOIN ailmentstatus s1
+      ON d.shakingdogstatusid = s1.id
+    JOIN ailmentstatus s2
+      ON d.cecsstatusid = s2.id
+    LEFT JOIN relationship r
+      ON d.id = r.childid
+    LEFT JOIN dog sire
+      ON sire.id = r.sireid
+    LEFT JOIN dog dam
+      ON dam.id = r.damid
+    ORDER BY d.name`,
+  )
+  if err != nil {
+    return err
+  }
+  defer rows.Close()
+
+  // parse and hand over result(s)
+  for rows.Next() {
+    var entry data.RegisterEntry
+    err := rows.Scan(
+      &entry.Dog.Id,
+      &entry.Dog.Name,
+      &entry.Dog.Gender,
+      &entry.Dog.ShakingDogStatus,
+      &entry.Dog.CecsStatus,
+      &entry.Dog.ShakingDogInferOverride,
+      &entry.Dog.CecsInferOverride,
+      &entry.SireName,
+      &entry.DamName,
+    )
+    if err != nil {
+      return err
+    }
+    err = handler(&entry)
+    if err != nil {
+      return err
+    }
+  }
+  return rows.Err()
+}
+
+func FindDogsByName(dbConn *Connection, pattern string) ([]data.Dog, error) {
+  // fetches all dogs with names matching a LIKE pattern
+  rows, err := dbConn.Query(`
+    SELECT d.id, d.name, d.gender, s1.status, s2.status, d.shakingdoginferoverride, d.cecsinferoverride
+    FROM dog d
+    JOIN ailmentstatus s1
+      ON d.shakingdogstatusid = s1.id
+    JOIN ailmentstatus s2
+      ON d.cecsstatusid = s2.id
+    WHERE d.name LIKE ?
+    ORDER BY d.name`,
+    pattern,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  dogs, err := _DogsFromRows(rows)
+  if err != nil {
+    return nil, err
+  }
+  return dogs, nil
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+
+func GetHistoryStart(dbConn *Connection) (string, error) {
+  // fetches when the history begins, to the second, which is when it
+  // was first filled rather than when the register began, or "" if
+  // there is none
+  var start string
+  err := dbConn.QueryRow(`
+    SELECT IFNULL(DATE_FORMAT(MIN(validfrom), '%Y-%m-%d %H:%i:%s'), '')
+    FROM doghistory`,
+  ).Scan(&start)
+  return start, err
+}
+
+func GetDogsAsOf(dbConn *Connection, asOf string) ([]data.Dog, error) {
+  // fetches all dogs as they stood just before a point in time, from
+  // the latest history row of each dog
+  rows, err := dbConn.Query(`
+    SELECT h.dogid, h.name, h.gender, s1.status, s2.status, h.shakingdoginferoverride, h.cecsinferoverride
+    FROM doghistory h
+    JOIN (
+      SELECT MAX(id) AS id
+      FROM doghistory
+      WHERE validfrom < ?
+      GROUP BY dogid
+    ) latest
+      ON latest.id = h.id
+    JOIN ailmentstatus s1
+      ON h.shakingdogstatusid = s1.id
+    JOIN ailmentstatus s2
+      ON h.cecsstatusid = s2.id
+    WHERE NOT h.deleted`,
+    asOf,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  dogs, err := _DogsFromRows(rows)
+  if err != nil {
+    return nil, err
+  }
+  return dogs, nil
+}
+
+func GetRelationshipsAsOf(dbConn *Connection, asOf string) ([]data.Relationship, error) {
+  // fetches all relationships as they stood just before a point in
+  // time, with the names and statuses of the dogs at that time
+  dogs, err := GetDogsAsOf(dbConn, asOf)
+  if err != nil {
+    return nil, err
+  }
+  dogsById := map[int]data.Dog{}
+  for _, dog := range dogs {
+    dogsById[dog.Id] = dog
+  }
+  rows, err := dbConn.Query(`
+    SELECT h.sireid, h.damid, h.childid
+    FROM relationshiphistory h
+    JOIN (
+      SELECT MAX(id) AS id
+      FROM relationshiphistory
+      WHERE validfrom < ?
+      GROUP BY childid
+    ) latest
+      ON latest.id = h.id
+    WHERE NOT h.deleted`,
+    asOf,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  rships := []data.Relationship{}
+  for rows.Next() {
+    var r data.Relationship
+    err := rows.Scan(
+      &r.SireId,
+      &r.DamId,
+      &r.ChildId,
+    )
+    if err != nil {
+      return nil, err
+    }
+    sire, ok1 := dogsById[r.SireId]
+    dam, ok2 := dogsById[int(r.DamId)]
+    child, ok3 := dogsById[int(r.ChildId)]
+    if !ok1 || !ok2 || !ok3 {
+      continue
+    }
+    r.SireName, r.SireShakingDogStatus = sire.Name, sire.ShakingDogStatus
+    r.DamName, r.DamShakingDogStatus = dam.Name, dam.ShakingDogStatus
+    r.ChildName, r.ChildShakingDogStatus = child.Name, child.ShakingDogStatus
+    rships = append(rships, r)
+  }
+  return rships, rows.Err()
+}
@@ -0,0 +1,300 @@
+package db
+
+import (
+  "database/sql"
+  "fmt"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+
+func RevertAuditEntry(dbConn *Connection, entry *data.AuditEntry, actor string) error {
+  // applies the inverse of the change recorded by an audit entry,
+  // provided the record still holds the values the change left it with
+  if entry.Diff == nil || entry.EntityId == 0 {
+    return ErrNotRevertible
+  }
+  var err error
+  switch entry.EntityType + " " + entry.Operation {
+  case "dog update":
+    err = _RevertDogUpdate(dbConn, entry, actor)
+  case "relationship create", "relationship update", "relationship delete":
+    err = _RevertRelationship(dbConn, entry, actor)
+  default:
+    err = ErrNotRevertible
+  }
+  if err != nil {
+    return err
+  }
+
+  // audit entry, referencing the one reverted
+  return SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Reverted audit entry %d; %s", entry.Id, entry.Action),
+    EntityType: entry.EntityType,
+    EntityId: entry.EntityId,
+    Operation: "revert",
+    RevertsId: entry.Id,
+  })
+}
+
+func _RevertDogUpdate(dbConn *Connection, entry *data.AuditEntry, actor string) error {
+  // the dog is locked until the revert is saved, so that it can't be
+  // changed between the check and the update
+  dog, err := _GetDogForUpdate(dbConn, entry.EntityId)
+  if err == sql.ErrNoRows {
+    return ErrRevertConflict
+  } else if err != nil {
+    return err
+  }
+  current := map[string]interface{}{
+    "name": dog.Name,
+    "gender": dog.Gender,
+    "shakingdogstatus": dog.ShakingDogStatus,
+    "cecsstatus": dog.CecsStatus,
+    "shakingdoginferoverride": dog.ShakingDogInferOverride,
+    "cecsinferoverride": dog.CecsInferOverride,
+  }
+  reverted, err := _RevertedValues(entry.Diff, current)
+  if err != nil {
+    return err
+  }
+
+  // same rule as an update, i.e. no gender change for a parent
+  if reverted["gender"] != dog.Gender {
+    families, err := GetFamiliesOfSire(dbConn, dog.Id)
+    if err != nil {
+      return err
+    }
+    damFamilies, err := GetFamiliesOfDam(dbConn, dog.Id)
+    if err != nil {
+      return err
+    }
+    if len(families) + len(damFamilies) > 0 {
+      return ErrRevertConflict
+    }
+  }
+  if reverted["name"] != dog.Name || reverted["gender"] != dog.Gender {
+    err = UpdateDog(dbConn, dog.Id, reverted["name"], reverted["gender"], actor)
+    if err != nil {
+      return err
+    }
+  }
+
+  // the override flags are put back too, which the stored proc can't
+  // do as it never clears them
+  shakingDogOverride := reverted["shakingdoginferoverride"] == "true"
+  cecsOverride := reverted["cecsinferoverride"] == "true"
+  if reverted["shakingdogstatus"] != dog.ShakingDogStatus || reverted["cecsstatus"] != dog.CecsStatus ||
+     shakingDogOverride != dog.ShakingDogInferOverride || cecsOverride != dog.CecsInferOverride {
+    dog.Name = reverted["name"]
+    err = _SetStatusesAndFlags(dbConn, &dog, reverted["shakingdogstatus"], reverted["cecsstatus"], shakingDogOverride, cecsOverride, actor)
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func _SetStatusesAndFlags(dbConn *Connection, oldDog *data.Dog, shakingDogStatus, cecsStatus string, shakingDogOverride, cecsOverride bool, actor string) error {
+  // sets a dog's statuses and override flags to exactly the values given
+  result, err := dbConn.Exec(`
+    UPDATE dog d
+    JOIN ailmentstatus s1
+      ON s1.status = ?
+    JOIN ailmentstatus s2
+      ON s2.status = ?
+    SET d.shakingdogstatusid = s1.id, d.cecsstatusid = s2.id,
+      d.shakingdoginferoverride = ?, d.cecsinferoverride = ?
+    WHERE d.id = ?`,
+    shakingDogStatus,
+    cecsStatus,
+    shakingDogOverride,
+    cecsOverride,
+    oldDog.Id,
+  )
+  if err != nil {
+    return TranslateError(err)
+  }
+  count, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+  if count == 0 {
+    return ErrNotRevertible
+  }
+
+  // audit entry, as for an update of the statuses
+  diff := data.NewAuditDiff()
+  diff.Add("shakingdogstatus", oldDog.ShakingDogStatus, shakingDogStatus)
+  diff.Add("cecsstatus", oldDog.CecsStatus, cecsStatus)
+  diff.Add("shakingdoginferoverride", oldDog.ShakingDogInferOverride, shakingDogOverride)
+  diff.Add("cecsinferoverride", oldDog.CecsInferOverride, cecsOverride)
+  action := fmt.Sprintf("Updated SLEM status; Name = '%s'; Status '%s' => '%s'",
+    oldDog.Name,
+    oldDog.ShakingDogStatus,
+    shakingDogStatus,
+  )
+  if oldDog.CecsStatus != cecsStatus {
+    action = fmt.Sprintf("%s; CECS Status '%s' => '%s'",
+      action,
+      oldDog.CecsStatus,
+      cecsStatus,
+    )
+  }
+  if oldDog.ShakingDogInferOverride != shakingDogOverride || oldDog.CecsInferOverride != cecsOverride {
+    action = fmt.Sprintf("%s; Infer Overrides SLEM '%t' => '%t', CECS '%t' => '%t'",
+      action,
+      oldDog.ShakingDogInferOverride,
+      shakingDogOverride,
+      oldDog.CecsInferOverride,
+      cecsOverride,
+    )
+  }
+  return SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: action,
+    EntityType: "dog",
+    EntityId: oldDog.Id,
+    Operation: "update",
+    Diff: diff,
+  })
+}
+
+func _RevertRelationship(dbConn *Connection, entry *data.AuditEntry, actor string) error {
+  // the child and its relationship are locked until the revert is
+  // saved, so that its parents can't be changed in the meantime
+  childId := entry.EntityId
+  err := _LockDog(dbConn, childId)
+  if err == sql.ErrNoRows {
+    return ErrRevertConflict
+  } else if err != nil {
+    return err
+  }
+  currentSireId, currentDamId, err := _GetParentIdsForUpdate(dbConn, childId)
+  if err != nil && err != sql.ErrNoRows {
+    return err
+  }
+  hasParents := err == nil
+
+  // a created relationship is reverted by deleting it, and vice versa
+  switch entry.Operation {
+  case "create":
+    if !hasParents {
+      return ErrRevertConflict
+    }
+    _, err = _RevertedValues(entry.Diff, map[string]interface{}{"sireid": currentSireId, "damid": currentDamId})
+    if err != nil {
+      return err
+    }
+    return DeleteRelationship(dbConn, childId, actor)
+  case "delete":
+    if hasParents {
+      return ErrRevertConflict
+    }
+    sireId, err1 := _IdValue(entry.Diff.Before["sireid"])
+    damId, err2 := _IdValue(entry.Diff.Before["damid"])
+    if err1 != nil || err2 != nil {
+      return ErrNotRevertible
+    }
+    return SaveRelationship(dbConn, sireId, damId, childId, actor)
+  }
+
+  // otherwise, put back whichever parents were changed
+  if !hasParents {
+    return ErrRevertConflict
+  }
+  reverted, err := _RevertedValues(entry.Diff, map[string]interface{}{"sireid": currentSireId, "damid": currentDamId})
+  if err != nil {
+    return err
+  }
+  sireId, err1 := _IdValue(reverted["sireid"])
+  damId, err2 := _IdValue(reverted["damid"])
+  if err1 != nil || err2 != nil {
+    return ErrNotRevertible
+  }
+  return SaveRelationship(dbConn, sireId, damId, childId, actor)
+}
+
+func _GetDogForUpdate(dbConn *Connection, id int) (dog data.Dog, err error) {
+  // fetches an individual dog, locking it until the transaction ends
+  err = dbConn.QueryRow(`
+    SELECT d.id, d.name, d.gender, s1.status, s2.status, d.shakingdoginferoverride, d.cecsinferoverride
+    FROM dog d
+    JOIN ailmentstatus s1
+      ON d.shakingdogstatusid = s1.id
+    JOIN ailmentstatus s2
+      ON d.cecsstatusid = s2.id
+    WHERE d.id = ?
+    FOR UPDATE`,
+    id,
+  ).Scan(
+    &dog.Id,
+    &dog.Name,
+    &dog.Gender,
+    &dog.ShakingDogStatus,
+    &dog.CecsStatus,
+    &dog.ShakingDogInferOverride,
+    &dog.CecsInferOverride,
+  )
+  return
+}
+
+func _LockDog(dbConn *Connection, id int) error {
+  // locks a dog until the transaction ends, which also holds back any
+  // new relationship for it, as adding one has to check the dog exists
+  var lockedId int
+  return dbConn.QueryRow(`
+    SELECT id
+    FROM dog
+    WHERE id = ?
+    FOR UPDATE`,
+    id,
+  ).Scan(&lockedId)
+}
+
+func _GetParentIdsForUpdate(dbConn *Connection, childId int) (sireId int, damId int, err error) {
+  // fetches the ids of a child's parents, locking the relationship until
+  // the transaction ends
+  err = dbConn.QueryRow(`
+    SELECT sireid, damid
+    FROM relationship
+    WHERE childid = ?
+    FOR UPDATE`,
+    childId,
+  ).Scan(&sireId, &damId)
+  return
+}
+
+func _RevertedValues(diff *data.AuditDiff, current map[string]interface{}) (map[string]string, error) {
+  // checks every changed value is still as the change left it, and
+  // returns the current values with the changed ones put back
+  // NOTE: the diff has been through JSON, so values are compared as
+  //       text to allow for numbers coming back as json.Number
+  reverted := map[string]string{}
+  for key, value := range current {
+    reverted[key] = fmt.Sprint(value)
+  }
+  changed := false
+  for key, after := range diff.After {
+    value, ok := current[key]
+    if !ok {
+      return nil, ErrNotRevertible
+    }
+    if fmt.Sprint(value) != fmt.Sprint(after) {
+      return nil, ErrRevertConflict
+    }
+    reverted[key] = fmt.Sprint(diff.Before[key])
+    changed = true
+  }
+  if !changed {
+    return nil, ErrNotRevertible
+  }
+  return reverted, nil
+}
+
+func _IdValue(value interface{}) (int, error) {
+  var id int
+  _, err := fmt.Sscan(fmt.Sprint(value), &id)
+  return id, err
+}
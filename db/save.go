@@ -1,7 +1,11 @@
 package db
 
 import (
+  "database/sql"
+  "encoding/json"
   "fmt"
+  "strconv"
+  "time"
 
   "bitbucket.org/Rusty1958/shakingdog/data"
 )
@@ -11,18 +15,89 @@ import (
  *       invoked with the CALL command and use SELECT to return new IDs
  */
 
-func SaveAuditEntry(dbConn *Connection, actor, action string) error {
-  // save a new audit entry
-  _, err := dbConn.Exec(`
-    INSERT INTO audit (actor, action)
-    VALUES (?, ?)`,
-    data.Left(actor, 50),
-    action,
+func SaveAuditEntry(dbConn *Connection, entry *data.AuditEntry) error {
+  // save a new audit entry, where the action is a readable summary of
+  // the change and the entity, operation and diff are for querying
+  // NOTE: each entry is chained to the one before by hash, which needs
+  //       a transaction to hold the lock on the head of the chain
+  if dbConn.Tx == nil {
+    txConn, err := dbConn.Begin(nil, nil)
+    if err != nil {
+      return err
+    }
+    defer txConn.Rollback()
+    err = SaveAuditEntry(txConn, entry)
+    if err != nil {
+      return err
+    }
+    return txConn.Commit()
+  }
+  var entityType, operation, diff sql.NullString
+  var entityId, revertsId sql.NullInt64
+  if len(entry.EntityType) > 0 {
+    entityType = sql.NullString{String: entry.EntityType, Valid: true}
+    operation = sql.NullString{String: entry.Operation, Valid: true}
+  }
+  if entry.EntityId != 0 {
+    entityId = sql.NullInt64{Int64: int64(entry.EntityId), Valid: true}
+  }
+  if entry.RevertsId != 0 {
+    revertsId = sql.NullInt64{Int64: int64(entry.RevertsId), Valid: true}
+  }
+  if entry.Diff != nil {
+    content, err := json.Marshal(entry.Diff)
+    if err != nil {
+      return err
+    }
+    diff = sql.NullString{String: string(content), Valid: true}
+  }
+  if len(dbConn.AuditKey) == 0 {
+    return ErrNoAuditKey
+  }
+
+  // the hash is worked out before the insert, as the stamp is set here
+  // rather than defaulted, so that the entry is never updated after
+  prevHash, err := _LockAuditHead(dbConn)
+  if err != nil {
+    return err
+  }
+  stamp := time.Now().Unix()
+  hash := AuditHash(dbConn.AuditKey, prevHash, []sql.NullString{
+    sql.NullString{String: strconv.FormatInt(stamp, 10), Valid: true},
+    sql.NullString{String: data.Left(entry.Actor, 50), Valid: true},
+    sql.NullString{String: entry.Action, Valid: true},
+    entityType,
+    _NullIntString(entityId),
+    operation,
+    diff,
+    _NullIntString(revertsId),
+  })
+  result, err := dbConn.Exec(`
+    INSERT INTO audit (stamp, actor, action, entitytype, entityid, operation, diff, revertsid, prevhash, hash)
+    VALUES (FROM_UNIXTIME(?), ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+    stamp,
+    data.Left(entry.Actor, 50),
+    entry.Action,
+    entityType,
+    entityId,
+    operation,
+    diff,
+    revertsId,
+    prevHash,
+    hash,
   )
   if err != nil {
     return TranslateError(err)
   }
-  return nil
+  id, err := result.LastInsertId()
+  if err != nil {
+    return err
+  }
+  err = _MoveAuditHead(dbConn, id, hash)
+  if err != nil {
+    return err
+  }
+  return _SaveEvent(dbConn, id, entry)
 }
 
 func SaveNewDog(dbConn *Connection, dog *data.Dog, actor string) error {
@@ -37,15 +112,27 @@ func SaveNewDog(dbConn *Connection, dog *data.Dog, actor string) error {
   if err != nil {
     return TranslateError(err)
   }
-  err = SaveAuditEntry(
-    dbConn,
-    actor,
-    fmt.Sprintf("Saved new dog; Name = '%s'; Gender = '%s'; SLEM Status = '%s'",
+
+  // audit entry
+  diff := data.NewAuditDiff()
+  diff.Before = nil
+  diff.After["name"] = data.Left(dog.Name, 180)
+  diff.After["gender"] = data.Left(dog.Gender, 1)
+  diff.After["shakingdogstatus"] = data.Left(dog.ShakingDogStatus, 50)
+  diff.After["cecsstatus"] = data.Left(dog.CecsStatus, 50)
+  err = SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Saved new dog; Name = '%s'; Gender = '%s'; SLEM Status = '%s'; CECS Status = '%s'",
       data.Left(dog.Name, 180),
       data.Left(dog.Gender, 1),
       data.Left(dog.ShakingDogStatus, 50),
+      data.Left(dog.CecsStatus, 50),
     ),
-  )
+    EntityType: "dog",
+    EntityId: dog.Id,
+    Operation: "create",
+    Diff: diff,
+  })
   if err != nil {
     return TranslateError(err)
   }
@@ -53,8 +140,15 @@ func SaveNewDog(dbConn *Connection, dog *data.Dog, actor string) error {
 }
 
 func SaveRelationship(dbConn *Connection, sireId, damId, childId int, actor string) error {
+  // grab existing parents (if any) for audit entry
+  oldSire, oldDam, err := GetParents(dbConn, childId)
+  if err != nil && err != sql.ErrNoRows {
+    return TranslateError(err)
+  }
+  hadParents := err == nil
+
   // creates (or re-creates) a relationship
-  _, err := dbConn.Exec(`
+  _, err = dbConn.Exec(`
     DELETE FROM relationship
     WHERE childid = ?`,
     childId,
@@ -86,18 +180,76 @@ func SaveRelationship(dbConn *Connection, sireId, damId, childId int, actor stri
   if err != nil {
     return TranslateError(err)
   }
-  err = SaveAuditEntry(
-    dbConn,
-    actor,
-    fmt.Sprintf("Saved new relationship; Sire = '%s'; Dam = '%s'; Child = '%s'",
+  diff := data.NewAuditDiff()
+  operation := "create"
+  if hadParents {
+    operation = "update"
+    diff.Add("sireid", oldSire.Id, sireId)
+    diff.Add("damid", oldDam.Id, damId)
+  } else {
+    diff.Before = nil
+    diff.After["sireid"] = sireId
+    diff.After["damid"] = damId
+  }
+  err = SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Saved new relationship; Sire = '%s'; Dam = '%s'; Child = '%s'",
       sire.Name,
       dam.Name,
       child.Name,
     ),
+    EntityType: "relationship",
+    EntityId: childId,
+    Operation: operation,
+    Diff: diff,
+  })
+  if err != nil {
+    return TranslateError(err)
+  }
+  return nil
+}
+
+func DeleteRelationship(dbConn *Connection, childId int, actor string) error {
+  // grab names of dogs for audit entry
+  sire, dam, err := GetParents(dbConn, childId)
+  if err != nil {
+    return TranslateError(err)
+  }
+  child, err := GetDog(dbConn, childId)
+  if err != nil {
+    return TranslateError(err)
+  }
+
+  // removes the parents of a dog
+  _, err = dbConn.Exec(`
+    DELETE FROM relationship
+    WHERE childid = ?`,
+    childId,
   )
   if err != nil {
     return TranslateError(err)
   }
+
+  // audit entry
+  diff := data.NewAuditDiff()
+  diff.Before["sireid"] = sire.Id
+  diff.Before["damid"] = dam.Id
+  diff.After = nil
+  err = SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Deleted relationship; Sire = '%s'; Dam = '%s'; Child = '%s'",
+      sire.Name,
+      dam.Name,
+      child.Name,
+    ),
+    EntityType: "relationship",
+    EntityId: childId,
+    Operation: "delete",
+    Diff: diff,
+  })
+  if err != nil {
+    return TranslateError(err)
+  }
   return nil
 }
 
@@ -122,16 +274,25 @@ func UpdateDog(dbConn *Connection, dogId int, name, gender, actor string) error
   }
 
   // audit entry
-  err = SaveAuditEntry(
-    dbConn,
-    actor,
-    fmt.Sprintf("Updated details; Name = '%s' => '%s'; Gender '%s' => '%s'",
+  diff := data.NewAuditDiff()
+  diff.Add("name", dog.Name, data.Left(name, 180))
+  diff.Add("gender", dog.Gender, data.Left(gender, 1))
+  err = SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Updated details; Name = '%s' => '%s'; Gender '%s' => '%s'",
       dog.Name,
       data.Left(name, 180),
       dog.Gender,
       data.Left(gender, 1),
     ),
-  )
+    EntityType: "dog",
+    EntityId: dogId,
+    Operation: "update",
+    Diff: diff,
+  })
+  if err != nil {
+    return TranslateError(err)
+  }
   return nil
 }
 
@@ -163,15 +324,23 @@ func UpdateRelationshipDam(dbConn *Connection, damId, childId int, actor string)
   }
 
   // audit log
-  err = SaveAuditEntry(
-    dbConn,
-    actor,
-    fmt.Sprintf("Updated parent (Dam) of child; Child = '%s'; Dam '%s' => '%s'",
+  diff := data.NewAuditDiff()
+  diff.Add("damid", oldDam.Id, damId)
+  err = SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Updated parent (Dam) of child; Child = '%s'; Dam '%s' => '%s'",
       child.Name,
       oldDam.Name,
       newDam.Name,
     ),
-  )
+    EntityType: "relationship",
+    EntityId: childId,
+    Operation: "update",
+    Diff: diff,
+  })
+  if err != nil {
+    return TranslateError(err)
+  }
   return nil
 }
 
@@ -203,15 +372,23 @@ func UpdateRelationshipSire(dbConn *Connection, sireId, childId int, actor strin
   }
 
   // audit entry
-  err = SaveAuditEntry(
-    dbConn,
-    actor,
-    fmt.Sprintf("Updated parent (Sire) of child; Child = '%s'; Sire '%s' => '%s'",
+  diff := data.NewAuditDiff()
+  diff.Add("sireid", oldSire.Id, sireId)
+  err = SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Updated parent (Sire) of child; Child = '%s'; Sire '%s' => '%s'",
       child.Name,
       oldSire.Name,
       newSire.Name,
     ),
-  )
+    EntityType: "relationship",
+    EntityId: childId,
+    Operation: "update",
+    Diff: diff,
+  })
+  if err != nil {
+    return TranslateError(err)
+  }
   return nil
 }
 
@@ -236,15 +413,21 @@ func UpdateSlemStatus(dbConn *Connection, dog *data.Dog, status, actor string) e
   }
 
   // audit entry
-  err = SaveAuditEntry(
-    dbConn,
-    actor,
-    fmt.Sprintf("Updated SLEM status; Name = '%s'; Status '%s' => '%s'",
+  diff := data.NewAuditDiff()
+  diff.Add("shakingdogstatus", oldDog.ShakingDogStatus, data.Left(status, 50))
+  diff.Add("cecsstatus", oldDog.CecsStatus, data.Left(dog.CecsStatus, 50))
+  err = SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Updated SLEM status; Name = '%s'; Status '%s' => '%s'",
       dog.Name,
       oldDog.ShakingDogStatus,
       data.Left(status, 50),
     ),
-  )
+    EntityType: "dog",
+    EntityId: dog.Id,
+    Operation: "update",
+    Diff: diff,
+  })
   if err != nil {
     return TranslateError(err)
   }
@@ -277,16 +460,32 @@ func UpdateStatusesAndFlags(dbConn *Connection, dog *data.TestResultDog, actor s
     return TranslateError(err)
   }
 
-  // audit entry
-  err = SaveAuditEntry(
-    dbConn,
-    actor,
-    fmt.Sprintf("Updated SLEM status; Name = '%s'; Status '%s' => '%s'",
-      dog.Name,
-      oldDog.ShakingDogStatus,
-      data.Left(dog.ShakingDogStatus, 50),
-    ),
+  // audit entry, covering whichever statuses changed
+  diff := data.NewAuditDiff()
+  diff.Add("shakingdogstatus", oldDog.ShakingDogStatus, data.Left(dog.ShakingDogStatus, 50))
+  diff.Add("cecsstatus", oldDog.CecsStatus, data.Left(dog.CecsStatus, 50))
+  diff.Add("shakingdoginferoverride", oldDog.ShakingDogInferOverride, oldDog.ShakingDogInferOverride || overrideShakingDogInfer)
+  diff.Add("cecsinferoverride", oldDog.CecsInferOverride, oldDog.CecsInferOverride || overrideCecsInfer)
+  action := fmt.Sprintf("Updated SLEM status; Name = '%s'; Status '%s' => '%s'",
+    dog.Name,
+    oldDog.ShakingDogStatus,
+    data.Left(dog.ShakingDogStatus, 50),
   )
+  if oldDog.CecsStatus != data.Left(dog.CecsStatus, 50) {
+    action = fmt.Sprintf("%s; CECS Status '%s' => '%s'",
+      action,
+      oldDog.CecsStatus,
+      data.Left(dog.CecsStatus, 50),
+    )
+  }
+  err = SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: action,
+    EntityType: "dog",
+    EntityId: dog.Id,
+    Operation: "update",
+    Diff: diff,
+  })
   if err != nil {
     return TranslateError(err)
   }
@@ -0,0 +1,188 @@
+package db
+
+import (
+  "database/sql"
+  "fmt"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// audit entries read per query while looking for status changes, and
+// the most queries made for a single request, which bounds the search
+// for rare changes as the feeds can be requested by anyone
+const statusChangePage = 200
+const statusChangeMaxPages = 25
+
+// a name prefix matching at most this many dogs is looked up by their
+// ids, which is indexed, rather than by joining on their names
+const statusChangeMaxPrefixDogs = 1000
+
+// the diff keys of each ailment's status
+var statusKeys = []struct {
+  Ailment string
+  Key string
+}{
+  {"SLEM", "shakingdogstatus"},
+  {"CECS", "cecsstatus"},
+}
+
+
+func GetStatusChanges(dbConn *Connection, filter *data.StatusChangeFilter) ([]data.StatusChange, error) {
+  // fetches the most recent status changes matching the filter, newest
+  // first, from the audit entries of created and updated dogs
+  conditions := []string{
+    "a.entitytype = 'dog'",
+    "a.operation IN ('create', 'update')",
+    "a.diff IS NOT NULL",
+  }
+  args := []interface{}{}
+  if filter.DogId > 0 {
+    conditions = append(conditions, "a.entityid = ?")
+    args = append(args, filter.DogId)
+  }
+  if len(filter.NamePrefix) > 0 {
+    dogIds, err := _DogIdsByPrefix(dbConn, filter.NamePrefix, statusChangeMaxPrefixDogs + 1)
+    if err != nil {
+      return nil, err
+    }
+    if len(dogIds) == 0 {
+      return []data.StatusChange{}, nil
+    }
+    if len(dogIds) <= statusChangeMaxPrefixDogs {
+      conditions = append(conditions, "a.entityid IN (?" + strings.Repeat(", ?", len(dogIds) - 1) + ")")
+      for _, dogId := range dogIds {
+        args = append(args, dogId)
+      }
+    } else {
+      conditions = append(conditions, "d.name LIKE ?")
+      args = append(args, EscapeLike(filter.NamePrefix) + "%")
+    }
+  }
+
+  // page through the entries until there are enough changes, as not
+  // every entry changes a status, but only so far back
+  changes := []data.StatusChange{}
+  before := 0
+  for page := 0; page < statusChangeMaxPages && len(changes) < filter.Limit; page++ {
+    pageConditions := append([]string{}, conditions...)
+    pageArgs := append([]interface{}{}, args...)
+    if before > 0 {
+      pageConditions = append(pageConditions, "a.id < ?")
+      pageArgs = append(pageArgs, before)
+    }
+    pageArgs = append(pageArgs, statusChangePage)
+    entries, names, err := _StatusEntries(dbConn, pageConditions, pageArgs)
+    if err != nil {
+      return nil, err
+    }
+    for i, entry := range entries {
+      for _, status := range statusKeys {
+        after, ok := entry.Diff.After[status.Key]
+        if !ok {
+          continue
+        }
+        change := data.StatusChange{
+          AuditId: entry.Id,
+          Stamp: entry.Stamp,
+          Actor: entry.Actor,
+          DogId: entry.EntityId,
+          DogName: names[i],
+          Ailment: status.Ailment,
+          After: fmt.Sprint(after),
+        }
+        if entry.Diff.Before != nil && entry.Diff.Before[status.Key] != nil {
+          change.Before = fmt.Sprint(entry.Diff.Before[status.Key])
+        }
+        if len(filter.Statuses) > 0 && !data.StringInSlice(filter.Statuses, change.After) {
+          continue
+        }
+        changes = append(changes, change)
+      }
+      before = entry.Id
+    }
+    if len(entries) < statusChangePage {
+      break
+    }
+  }
+  if len(changes) > filter.Limit {
+    changes = changes[:filter.Limit]
+  }
+  return changes, nil
+}
+
+func _DogIdsByPrefix(dbConn *Connection, prefix string, limit int) ([]int, error) {
+  // fetches the ids of up to limit dogs whose names start with prefix
+  rows, err := dbConn.Query(`
+    SELECT id
+    FROM dog
+    WHERE name LIKE ?
+    LIMIT ?`,
+    EscapeLike(prefix) + "%",
+    limit,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+  ids := []int{}
+  for rows.Next() {
+    var id int
+    err = rows.Scan(&id)
+    if err != nil {
+      return nil, err
+    }
+    ids = append(ids, id)
+  }
+  return ids, rows.Err()
+}
+
+func _StatusEntries(dbConn *Connection, conditions []string, args []interface{}) ([]data.AuditEntry, []string, error) {
+  // fetches a page of dog audit entries, along with each dog's name
+  rows, err := dbConn.Query(`
+    SELECT a.id, a.stamp, a.actor, a.action, a.entitytype, a.entityid, a.operation, a.diff, a.revertsid, d.name
+    FROM audit a
+    JOIN dog d
+      ON a.entityid = d.id
+    WHERE ` + strings.Join(conditions, " AND ") + `
+    ORDER BY a.id DESC
+    LIMIT ?`,
+    args...,
+  )
+  if err != nil {
+    return nil, nil, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  entries := []data.AuditEntry{}
+  names := []string{}
+  for rows.Next() {
+    var entry data.AuditEntry
+    var name string
+    var entityType, operation, diff sql.NullString
+    var entityId, revertsId sql.NullInt64
+    err = rows.Scan(
+      &entry.Id,
+      &entry.Stamp,
+      &entry.Actor,
+      &entry.Action,
+      &entityType,
+      &entityId,
+      &operation,
+      &diff,
+      &revertsId,
+      &name,
+    )
+    if err != nil {
+      return nil, nil, err
+    }
+    err = _FillAuditEntry(&entry, entityType, entityId, operation, diff, revertsId)
+    if err != nil {
+      return nil, nil, err
+    }
+    entries = append(entries, entry)
+    names = append(names, name)
+  }
+  return entries, names, rows.Err()
+}
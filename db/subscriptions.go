@@ -0,0 +1,332 @@
+package db
+
+import (
+  "database/sql"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+
+func _SubscriptionsFromRows(rows *sql.Rows) ([]data.Subscription, error) {
+  // utility function that constructs a list of Subscription
+  // objects from the results of a SQL query
+  subscriptions := []data.Subscription{}
+  for rows.Next() {
+    var subscription data.Subscription
+    err := rows.Scan(
+      &subscription.Id,
+      &subscription.Stamp,
+      &subscription.Email,
+      &subscription.Dog.Id,
+      &subscription.Dog.Name,
+      &subscription.Descendants,
+    )
+    if err != nil {
+      return nil, err
+    }
+    subscriptions = append(subscriptions, subscription)
+  }
+  return subscriptions, nil
+}
+
+func GetSubscriptions(dbConn *Connection, username string) ([]data.Subscription, error) {
+  // fetches a user's subscriptions
+  rows, err := dbConn.Query(`
+    SELECT s.id, s.stamp, s.email, d.id, d.name, s.descendants
+    FROM subscription s
+    JOIN dog d
+      ON s.dogid = d.id
+    WHERE s.username = ?
+    ORDER BY d.name`,
+    username,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  subscriptions, err := _SubscriptionsFromRows(rows)
+  if err != nil {
+    return nil, err
+  }
+  return subscriptions, nil
+}
+
+func SaveSubscription(dbConn *Connection, subscription *data.Subscription, username, token string) error {
+  // saves a new subscription, with the token used to unsubscribe
+  // without logging in
+  result, err := dbConn.Exec(`
+    INSERT INTO subscription (username, email, dogid, descendants, token)
+    VALUES (?, ?, ?, ?, ?)`,
+    data.Left(username, 50),
+    data.Left(subscription.Email, 254),
+    subscription.Dog.Id,
+    subscription.Descendants,
+    token,
+  )
+  if err != nil {
+    return TranslateError(err)
+  }
+  id, err := result.LastInsertId()
+  if err != nil {
+    return err
+  }
+  subscription.Id = int(id)
+  return nil
+}
+
+func DeleteSubscription(dbConn *Connection, id int, username string) error {
+  // removes one of a user's subscriptions
+  result, err := dbConn.Exec(`
+    DELETE FROM subscription
+    WHERE id = ? AND username = ?`,
+    id,
+    username,
+  )
+  return _DeletedSubscription(dbConn, result, err)
+}
+
+func GetSubscriptionByToken(dbConn *Connection, token string) (data.Subscription, error) {
+  // fetches the subscription an unsubscribe link was sent for
+  var subscription data.Subscription
+  rows, err := dbConn.Query(`
+    SELECT s.id, s.stamp, s.email, d.id, d.name, s.descendants
+    FROM subscription s
+    JOIN dog d
+      ON s.dogid = d.id
+    WHERE s.token = ?`,
+    token,
+  )
+  if err != nil {
+    return subscription, err
+  }
+  defer rows.Close()
+
+  // parse result
+  subscriptions, err := _SubscriptionsFromRows(rows)
+  if err != nil {
+    return subscription, err
+  }
+  if len(subscriptions) == 0 {
+    return subscription, sql.ErrNoRows
+  }
+  return subscriptions[0], nil
+}
+
+func DeleteSubscriptionByToken(dbConn *Connection, token string) error {
+  // removes the subscription an unsubscribe link was sent for
+  result, err := dbConn.Exec(`
+    DELETE FROM subscription
+    WHERE token = ?`,
+    token,
+  )
+  return _DeletedSubscription(dbConn, result, err)
+}
+
+func _DeletedSubscription(dbConn *Connection, result sql.Result, err error) error {
+  // drops anything still to be sent for a removed subscription
+  if err != nil {
+    return TranslateError(err)
+  }
+  count, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+  if count == 0 {
+    return sql.ErrNoRows
+  }
+  _, err = dbConn.Exec(`
+    DELETE n
+    FROM notification n
+    LEFT JOIN subscription s
+      ON n.subscriptionid = s.id
+    WHERE s.id IS NULL AND n.sent IS NULL`,
+  )
+  return err
+}
+
+func _SaveNotifications(dbConn *Connection, eventId int64, dogId int) error {
+  // queues a notification for every subscription to the dog, and to
+  // any of its ancestors whose descendants are subscribed to
+  _, err := dbConn.Exec(`
+    INSERT INTO notification (subscriptionid, eventid, dogid)
+    SELECT id, ?, ?
+    FROM subscription
+    WHERE dogid = ?`,
+    eventId,
+    dogId,
+    dogId,
+  )
+  if err != nil {
+    return err
+  }
+
+  // the ancestors are only worth finding if anyone wants them
+  var count int
+  err = dbConn.QueryRow(`
+    SELECT COUNT(*)
+    FROM subscription
+    WHERE descendants`,
+  ).Scan(&count)
+  if err != nil || count == 0 {
+    return err
+  }
+  ancestorIds, err := _AncestorIds(dbConn, dogId)
+  if err != nil || len(ancestorIds) == 0 {
+    return err
+  }
+  args := []interface{}{eventId, dogId}
+  for _, ancestorId := range ancestorIds {
+    args = append(args, ancestorId)
+  }
+  _, err = dbConn.Exec(`
+    INSERT INTO notification (subscriptionid, eventid, dogid)
+    SELECT id, ?, ?
+    FROM subscription
+    WHERE descendants AND dogid IN (?` + strings.Repeat(", ?", len(ancestorIds) - 1) + `)`,
+    args...,
+  )
+  return err
+}
+
+func _AncestorIds(dbConn *Connection, dogId int) ([]int, error) {
+  // walks up the relationships from a dog, guarding against cycles
+  // from bad data
+  ancestorIds := []int{}
+  seen := map[int]bool{dogId: true}
+  pending := []int{dogId}
+  for len(pending) > 0 {
+    childId := pending[0]
+    pending = pending[1:]
+    var sireId, damId int
+    err := dbConn.QueryRow(`
+      SELECT sireid, damid
+      FROM relationship
+      WHERE childid = ?`,
+      childId,
+    ).Scan(&sireId, &damId)
+    if err == sql.ErrNoRows {
+      continue
+    } else if err != nil {
+      return nil, err
+    }
+    for _, parentId := range []int{sireId, damId} {
+      if !seen[parentId] {
+        seen[parentId] = true
+        ancestorIds = append(ancestorIds, parentId)
+        pending = append(pending, parentId)
+      }
+    }
+  }
+  return ancestorIds, nil
+}
+
+func ClaimNotifications(dbConn *Connection, leaseSeconds int) ([]data.Notification, error) {
+  // fetches every unsent notification, oldest first, that isn't already
+  // claimed, and claims them for the lease so that they aren't picked
+  // up again while being sent
+  // NOTE: expected to be run in its own transaction, which holds the
+  //       row locks (of the notifications only) until the lease is saved
+  rows, err := dbConn.Query(`
+    SELECT n.id, s.email, s.token, sd.name, s.descendants, d.name, a.stamp, a.actor, a.action
+    FROM notification n
+    JOIN subscription s
+      ON n.subscriptionid = s.id
+    JOIN dog sd
+      ON s.dogid = sd.id
+    JOIN dog d
+      ON n.dogid = d.id
+    JOIN event e
+      ON n.eventid = e.id
+    JOIN audit a
+      ON e.auditid = a.id
+    WHERE n.sent IS NULL AND (n.claimeduntil IS NULL OR n.claimeduntil <= CURRENT_TIMESTAMP)
+    ORDER BY n.id
+    FOR UPDATE OF n`,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+  notifications := []data.Notification{}
+  for rows.Next() {
+    var notification data.Notification
+    err = rows.Scan(
+      &notification.Id,
+      &notification.Email,
+      &notification.Token,
+      &notification.SubscribedName,
+      &notification.Descendants,
+      &notification.DogName,
+      &notification.Stamp,
+      &notification.Actor,
+      &notification.Action,
+    )
+    if err != nil {
+      return nil, err
+    }
+    notifications = append(notifications, notification)
+  }
+  err = rows.Err()
+  if err != nil {
+    return nil, err
+  }
+  rows.Close()
+
+  // take the lease
+  if len(notifications) == 0 {
+    return notifications, nil
+  }
+  args := []interface{}{leaseSeconds}
+  for _, notification := range notifications {
+    args = append(args, notification.Id)
+  }
+  _, err = dbConn.Exec(`
+    UPDATE notification
+    SET claimeduntil = CURRENT_TIMESTAMP + INTERVAL ? SECOND
+    WHERE id IN (?` + strings.Repeat(", ?", len(notifications) - 1) + `)`,
+    args...,
+  )
+  if err != nil {
+    return nil, err
+  }
+  return notifications, nil
+}
+
+func MarkNotificationsSent(dbConn *Connection, ids []int) error {
+  if len(ids) == 0 {
+    return nil
+  }
+  args := []interface{}{}
+  for _, id := range ids {
+    args = append(args, id)
+  }
+  _, err := dbConn.Exec(`
+    UPDATE notification
+    SET sent = CURRENT_TIMESTAMP
+    WHERE id IN (?` + strings.Repeat(", ?", len(ids) - 1) + `)`,
+    args...,
+  )
+  return err
+}
+
+func ReleaseNotifications(dbConn *Connection, ids []int) error {
+  // gives up the claim on notifications that weren't sent, so that they
+  // go with the next batch
+  if len(ids) == 0 {
+    return nil
+  }
+  args := []interface{}{}
+  for _, id := range ids {
+    args = append(args, id)
+  }
+  _, err := dbConn.Exec(`
+    UPDATE notification
+    SET claimeduntil = NULL
+    WHERE id IN (?` + strings.Repeat(", ?", len(ids) - 1) + `) AND sent IS NULL`,
+    args...,
+  )
+  return err
+}
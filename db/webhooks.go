@@ -0,0 +1,346 @@
+package db
+
+import (
+  "database/sql"
+  "fmt"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+const (
+  DeliveryPending = "pending"
+  DeliveryDelivered = "delivered"
+  DeliveryFailed = "failed"
+  DeliveryCancelled = "cancelled"
+)
+
+
+func _WebhooksFromRows(rows *sql.Rows) ([]data.Webhook, error) {
+  // utility function that constructs a list of Webhook
+  // objects from the results of a SQL query
+  // NOTE: the secret is never read back
+  hooks := []data.Webhook{}
+  for rows.Next() {
+    var hook data.Webhook
+    var events string
+    err := rows.Scan(
+      &hook.Id,
+      &hook.Stamp,
+      &hook.Url,
+      &events,
+      &hook.Active,
+      &hook.CreatedBy,
+    )
+    if err != nil {
+      return nil, err
+    }
+    hook.Events = strings.Split(events, ",")
+    hooks = append(hooks, hook)
+  }
+  return hooks, nil
+}
+
+func _DeliveriesFromRows(rows *sql.Rows) ([]data.WebhookDelivery, error) {
+  // utility function that constructs a list of WebhookDelivery
+  // objects from the results of a SQL query
+  deliveries := []data.WebhookDelivery{}
+  for rows.Next() {
+    var delivery data.WebhookDelivery
+    var nextAttempt, lastAttempt, lastError sql.NullString
+    var responseCode sql.NullInt64
+    err := rows.Scan(
+      &delivery.Id,
+      &delivery.WebhookId,
+      &delivery.EventId,
+      &delivery.EventType,
+      &delivery.Status,
+      &delivery.Attempts,
+      &nextAttempt,
+      &lastAttempt,
+      &responseCode,
+      &lastError,
+    )
+    if err != nil {
+      return nil, err
+    }
+    delivery.NextAttempt = nextAttempt.String
+    delivery.LastAttempt = lastAttempt.String
+    delivery.ResponseCode = int(responseCode.Int64)
+    delivery.Error = lastError.String
+    deliveries = append(deliveries, delivery)
+  }
+  return deliveries, nil
+}
+
+func GetWebhooks(dbConn *Connection) ([]data.Webhook, error) {
+  // fetches every webhook, including those since removed
+  rows, err := dbConn.Query(`
+    SELECT id, stamp, url, events, active, createdby
+    FROM webhook
+    ORDER BY id`,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  hooks, err := _WebhooksFromRows(rows)
+  if err != nil {
+    return nil, err
+  }
+  return hooks, nil
+}
+
+func GetWebhook(dbConn *Connection, id int) (data.Webhook, error) {
+  // fetches an individual webhook
+  rows, err := dbConn.Query(`
+    SELECT id, stamp, url, events, active, createdby
+    FROM webhook
+    WHERE id = ?`,
+    id,
+  )
+  if err != nil {
+    return data.Webhook{}, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  hooks, err := _WebhooksFromRows(rows)
+  if err != nil {
+    return data.Webhook{}, err
+  }
+  if len(hooks) == 0 {
+    return data.Webhook{}, sql.ErrNoRows
+  }
+  return hooks[0], nil
+}
+
+func SaveWebhook(dbConn *Connection, hook *data.Webhook, actor string) error {
+  // saves a new webhook, active from the next event
+  result, err := dbConn.Exec(`
+    INSERT INTO webhook (url, secret, events, active, createdby)
+    VALUES (?, ?, ?, 1, ?)`,
+    data.Left(hook.Url, 500),
+    data.Left(hook.Secret, 100),
+    strings.Join(hook.Events, ","),
+    data.Left(actor, 50),
+  )
+  if err != nil {
+    return TranslateError(err)
+  }
+  id, err := result.LastInsertId()
+  if err != nil {
+    return err
+  }
+  hook.Id = int(id)
+  hook.Active = true
+  hook.CreatedBy = data.Left(actor, 50)
+
+  // audit entry
+  diff := data.NewAuditDiff()
+  diff.Before = nil
+  diff.After["url"] = data.Left(hook.Url, 500)
+  diff.After["events"] = strings.Join(hook.Events, ",")
+  return SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Created webhook; URL = '%s'; Events = '%s'",
+      data.Left(hook.Url, 500),
+      strings.Join(hook.Events, ","),
+    ),
+    EntityType: "webhook",
+    EntityId: hook.Id,
+    Operation: "create",
+    Diff: diff,
+  })
+}
+
+func DeleteWebhook(dbConn *Connection, id int, actor string) error {
+  // deactivates a webhook and cancels its undelivered events, keeping
+  // its delivery log
+  hook, err := GetWebhook(dbConn, id)
+  if err != nil {
+    return err
+  }
+  _, err = dbConn.Exec(`
+    UPDATE webhook
+    SET active = 0
+    WHERE id = ?`,
+    id,
+  )
+  if err != nil {
+    return TranslateError(err)
+  }
+  _, err = dbConn.Exec(`
+    UPDATE webhookdelivery
+    SET status = ?, nextattempt = NULL
+    WHERE webhookid = ? AND status = ?`,
+    DeliveryCancelled,
+    id,
+    DeliveryPending,
+  )
+  if err != nil {
+    return TranslateError(err)
+  }
+
+  // audit entry
+  diff := data.NewAuditDiff()
+  diff.Add("active", hook.Active, false)
+  return SaveAuditEntry(dbConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Removed webhook; URL = '%s'", hook.Url),
+    EntityType: "webhook",
+    EntityId: id,
+    Operation: "delete",
+    Diff: diff,
+  })
+}
+
+func GetWebhookDeliveries(dbConn *Connection, webhookId, before, limit int) ([]data.WebhookDelivery, error) {
+  // fetches a page of a webhook's delivery log, newest first
+  conditions := []string{"d.webhookid = ?"}
+  args := []interface{}{webhookId}
+  if before > 0 {
+    conditions = append(conditions, "d.id < ?")
+    args = append(args, before)
+  }
+  args = append(args, limit)
+  rows, err := dbConn.Query(`
+    SELECT d.id, d.webhookid, d.eventid, e.type, d.status, d.attempts, d.nextattempt, d.lastattempt, d.responsecode, d.error
+    FROM webhookdelivery d
+    JOIN event e
+      ON d.eventid = e.id
+    WHERE ` + strings.Join(conditions, " AND ") + `
+    ORDER BY d.id DESC
+    LIMIT ?`,
+    args...,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  // parse result(s)
+  deliveries, err := _DeliveriesFromRows(rows)
+  if err != nil {
+    return nil, err
+  }
+  return deliveries, nil
+}
+
+func RetryWebhookDelivery(dbConn *Connection, webhookId, deliveryId int) error {
+  // queues a failed delivery to be attempted again from scratch
+  result, err := dbConn.Exec(`
+    UPDATE webhookdelivery d
+    JOIN webhook w
+      ON d.webhookid = w.id
+    SET d.status = ?, d.attempts = 0, d.nextattempt = CURRENT_TIMESTAMP
+    WHERE d.id = ? AND d.webhookid = ? AND d.status = ? AND w.active`,
+    DeliveryPending,
+    deliveryId,
+    webhookId,
+    DeliveryFailed,
+  )
+  if err != nil {
+    return TranslateError(err)
+  }
+  count, err := result.RowsAffected()
+  if err != nil {
+    return err
+  }
+  if count == 0 {
+    return sql.ErrNoRows
+  }
+  return nil
+}
+
+func ClaimDueDeliveries(dbConn *Connection, limit, leaseSeconds int) ([]data.DueDelivery, error) {
+  // fetches deliveries whose next attempt is due and pushes their next
+  // attempt back by the lease, so that they aren't picked up again
+  // while being attempted
+  // NOTE: expected to be run in its own transaction, which holds the
+  //       row locks until the lease is saved
+  rows, err := dbConn.Query(`
+    SELECT d.id, d.eventid, d.attempts, w.url, w.secret
+    FROM webhookdelivery d
+    JOIN webhook w
+      ON d.webhookid = w.id
+    WHERE d.status = ? AND d.nextattempt <= CURRENT_TIMESTAMP
+    ORDER BY d.id
+    LIMIT ?
+    FOR UPDATE`,
+    DeliveryPending,
+    limit,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+  due := []data.DueDelivery{}
+  for rows.Next() {
+    var delivery data.DueDelivery
+    err = rows.Scan(
+      &delivery.Id,
+      &delivery.EventId,
+      &delivery.Attempts,
+      &delivery.Url,
+      &delivery.Secret,
+    )
+    if err != nil {
+      return nil, err
+    }
+    due = append(due, delivery)
+  }
+  err = rows.Err()
+  if err != nil {
+    return nil, err
+  }
+  rows.Close()
+
+  // take the lease
+  for _, delivery := range due {
+    _, err = dbConn.Exec(`
+      UPDATE webhookdelivery
+      SET nextattempt = CURRENT_TIMESTAMP + INTERVAL ? SECOND
+      WHERE id = ?`,
+      leaseSeconds,
+      delivery.Id,
+    )
+    if err != nil {
+      return nil, err
+    }
+  }
+  return due, nil
+}
+
+func SaveDeliveryAttempt(dbConn *Connection, id int, status string, attempts, retrySeconds, responseCode int, attemptError string) error {
+  // records the outcome of an attempt, with the next attempt (if any)
+  // due after the retry delay
+  var nextAttempt interface{}
+  if status == DeliveryPending {
+    nextAttempt = retrySeconds
+  }
+  var code sql.NullInt64
+  if responseCode != 0 {
+    code = sql.NullInt64{Int64: int64(responseCode), Valid: true}
+  }
+  var errorText sql.NullString
+  if len(attemptError) > 0 {
+    errorText = sql.NullString{String: data.Left(attemptError, 500), Valid: true}
+  }
+  _, err := dbConn.Exec(`
+    UPDATE webhookdelivery
+    SET status = ?, attempts = ?, nextattempt = CURRENT_TIMESTAMP + INTERVAL ? SECOND,
+      lastattempt = CURRENT_TIMESTAMP, responsecode = ?, error = ?
+    WHERE id = ? AND status = ?`,
+    status,
+    attempts,
+    nextAttempt,
+    code,
+    errorText,
+    id,
+    DeliveryPending,
+  )
+  return TranslateError(err)
+}
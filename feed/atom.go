@@ -0,0 +1,105 @@
+package feed
+
+import (
+  "encoding/xml"
+  "fmt"
+  "io"
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/certificate"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// changes to these statuses are news, unlike a status being reset to
+// Unknown
+var ResultStatuses = []string{"Affected", "Carrier", "Clear", "CarrierByProgeny", "ClearByParentage"}
+
+type Feed struct {
+  XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+  Id string `xml:"id"`
+  Title string `xml:"title"`
+  Updated string `xml:"updated"`
+  Links []Link `xml:"link"`
+  Author Person `xml:"author"`
+  Entries []Entry `xml:"entry"`
+}
+
+type Link struct {
+  Rel string `xml:"rel,attr,omitempty"`
+  Type string `xml:"type,attr,omitempty"`
+  Href string `xml:"href,attr"`
+}
+
+type Person struct {
+  Name string `xml:"name"`
+}
+
+type Entry struct {
+  Id string `xml:"id"`
+  Title string `xml:"title"`
+  Updated string `xml:"updated"`
+  Links []Link `xml:"link"`
+  Summary string `xml:"summary"`
+}
+
+
+func New(title, selfUrl string, changes []data.StatusChange, dogUrl func(int) string) *Feed {
+  // builds a feed with an entry per status change, newest first
+  feed := &Feed{
+    Id: selfUrl,
+    Title: title,
+    Updated: time.Now().Format(time.RFC3339),
+    Links: []Link{{Rel: "self", Type: "application/atom+xml", Href: selfUrl}},
+    Author: Person{Name: "SLEM / CECS Register"},
+    Entries: []Entry{},
+  }
+  for i, change := range changes {
+    updated := Timestamp(change.Stamp)
+    if i == 0 {
+      feed.Updated = updated
+    }
+    feed.Entries = append(feed.Entries, Entry{
+      Id: fmt.Sprintf("%s#audit-%d-%s", dogUrl(change.DogId), change.AuditId, change.Ailment),
+      Title: fmt.Sprintf("%s: %s %s", change.DogName, change.Ailment, certificate.Describe(change.After)),
+      Updated: updated,
+      Links: []Link{{Rel: "alternate", Href: dogUrl(change.DogId)}},
+      Summary: Summary(change),
+    })
+  }
+  return feed
+}
+
+func Summary(change data.StatusChange) string {
+  // describes a change without naming the user who made it
+  summary := fmt.Sprintf("%s status of %s recorded as %s",
+    change.Ailment,
+    change.DogName,
+    certificate.Describe(change.After),
+  )
+  if len(change.Before) > 0 {
+    summary = fmt.Sprintf("%s, previously %s", summary, certificate.Describe(change.Before))
+  }
+  if change.Actor == "System" {
+    summary += ", by the register's inference from relatives"
+  }
+  return summary + "."
+}
+
+func Timestamp(stamp string) string {
+  // converts a DB timestamp, held in server time, to RFC 3339
+  t, err := time.ParseInLocation("2006-01-02 15:04:05", stamp, time.Local)
+  if err != nil {
+    return stamp
+  }
+  return t.Format(time.RFC3339)
+}
+
+func (f *Feed) Write(w io.Writer) error {
+  _, err := io.WriteString(w, xml.Header)
+  if err != nil {
+    return err
+  }
+  encoder := xml.NewEncoder(w)
+  encoder.Indent("", "  ")
+  return encoder.Encode(f)
+}
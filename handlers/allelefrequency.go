@@ -0,0 +1,40 @@
+package handlers
+
+import (
+  "encoding/json"
+  "log"
+  "net/http"
+
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
+)
+
+
+func AlleleFrequencyHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  // unrelated dogs give the least biased estimate
+  sample := pedigree.SampleUnrelated
+  if params["sample"] != nil {
+    sample = params["sample"][0]
+  }
+  if !pedigree.IsValidSample(sample) {
+    SendErrorResponse(w, ErrBadRequest, "Invalid sample")
+    return
+  }
+
+  // estimates need the whole register
+  p, err := pedigree.Load(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: AlleleFrequencyHandler: pedigree.Load error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(p.AlleleFrequencies(sample))
+  w.Write(data)
+}
@@ -1,28 +1,78 @@
 package handlers
 
 import (
-  "database/sql"
   "encoding/json"
   "log"
   "net/http"
+  "strconv"
 
   "bitbucket.org/Rusty1958/shakingdog/auth"
   "bitbucket.org/Rusty1958/shakingdog/data"
   "bitbucket.org/Rusty1958/shakingdog/db"
 )
 
+// entries returned per page, unless asked for otherwise
+const (
+  defaultAuditLimit = 100
+  maxAuditLimit = 1000
+)
+
 
 func AuditHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
   // Okta JWT provides group membership info
   oktaContext := req.Context()
   groups := auth.GroupsFromContext(oktaContext)
 
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  filter := data.AuditFilter{}
+  filter.Limit, err = OptionalInt(params, "limit", defaultAuditLimit)
+  if err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+    SendErrorResponse(w, ErrBadRequest, "Invalid limit")
+    return
+  }
+  filter.DogId, err = OptionalInt(params, "dogid", 0)
+  if err != nil || filter.DogId < 0 {
+    SendErrorResponse(w, ErrBadRequest, "Invalid dogid")
+    return
+  }
+  filter.From, err = OptionalTime(params, "from", false)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid from")
+    return
+  }
+  filter.To, err = OptionalTime(params, "to", true)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid to")
+    return
+  }
+  if params["actor"] != nil {
+    filter.Actor = params["actor"][0]
+  }
+  if params["q"] != nil {
+    filter.Search = params["q"][0]
+  }
+  systemCursor, err := OptionalInt(params, "systemcursor", 0)
+  if err != nil || systemCursor < 0 {
+    SendErrorResponse(w, ErrBadRequest, "Invalid systemcursor")
+    return
+  }
+  userCursor, err := OptionalInt(params, "usercursor", 0)
+  if err != nil || userCursor < 0 {
+    SendErrorResponse(w, ErrBadRequest, "Invalid usercursor")
+    return
+  }
+
   // fetch system logs
-  systemEntries, err := db.GetSystemAuditEntries(ctx.DBConn)
-  if err == sql.ErrNoRows {
-    systemEntries = []data.AuditEntry{}
-  } else if err != nil {
-    log.Printf("ERROR: AuditHandler: GetSystemAuditEntries error - %v", err)
+  filter.System = true
+  filter.Before = systemCursor
+  systemEntries, err := db.GetAuditEntries(ctx.DBConn, &filter)
+  if err != nil {
+    log.Printf("ERROR: AuditHandler: GetAuditEntries error - %v", err)
     SendErrorResponse(w, ErrServerError, "Database error")
     return
   }
@@ -30,9 +80,11 @@ func AuditHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
   // fetch user logs if allowed
   userEntries := []data.AuditEntry{}
   if auth.IsUserAuditAdmin(groups) {
-    userEntries, err = db.GetUserAuditEntries(ctx.DBConn)
-    if err != nil && err != sql.ErrNoRows {
-      log.Printf("ERROR: AuditHandler: GetUserAuditEntries error - %v", err)
+    filter.System = false
+    filter.Before = userCursor
+    userEntries, err = db.GetAuditEntries(ctx.DBConn, &filter)
+    if err != nil {
+      log.Printf("ERROR: AuditHandler: GetAuditEntries error - %v", err)
       SendErrorResponse(w, ErrServerError, "Database error")
       return
     }
@@ -42,6 +94,16 @@ func AuditHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
   data, _ := json.Marshal(data.AuditEntries{
     System: systemEntries,
     User: userEntries,
+    NextSystemCursor: nextAuditCursor(systemEntries, filter.Limit),
+    NextUserCursor: nextAuditCursor(userEntries, filter.Limit),
   })
   w.Write(data)
 }
+
+func nextAuditCursor(entries []data.AuditEntry, limit int) string {
+  // a full page means there may be more entries to fetch
+  if len(entries) < limit {
+    return ""
+  }
+  return strconv.Itoa(entries[len(entries) - 1].Id)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+  "encoding/json"
+  "log"
+  "net/http"
+
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+
+func AuditChainHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // check every audit entry against the hash chain
+  report, err := db.VerifyAuditChain(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: AuditChainHandler: VerifyAuditChain error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  if !report.Valid {
+    log.Printf("WARNING: AuditChainHandler: %d break(s) in audit chain", len(report.Breaks))
+  }
+
+  // marshal and send response
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(report)
+  w.Write(data)
+}
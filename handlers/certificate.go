@@ -0,0 +1,61 @@
+package handlers
+
+import (
+  "fmt"
+  "log"
+  "net/http"
+  "strconv"
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/certificate"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
+
+  "github.com/gorilla/mux"
+)
+
+
+func CertificateHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  cert, ok := dogCertificate(w, req, ctx, "CertificateHandler")
+  if !ok {
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  err := certificate.WriteHtml(w, cert)
+  if err != nil {
+    log.Printf("ERROR: CertificateHandler: WriteHtml error - %v", err)
+  }
+}
+
+func CertificatePdfHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  cert, ok := dogCertificate(w, req, ctx, "CertificatePdfHandler")
+  if !ok {
+    return
+  }
+  w.Header().Set("Content-Type", "application/pdf")
+  w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"certificate-%d.pdf\"", cert.Dog.Id))
+  err := certificate.WritePdf(w, cert)
+  if err != nil {
+    log.Printf("ERROR: CertificatePdfHandler: WritePdf error - %v", err)
+  }
+}
+
+func dogCertificate(w http.ResponseWriter, req *http.Request, ctx *Context, caller string) (*data.Certificate, bool) {
+  // builds the certificate for the dog in the URL, having sent an
+  // error response if that's not possible
+  p, err := pedigree.Load(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: %s: pedigree.Load error - %v", caller, err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return nil, false
+  }
+  vars := mux.Vars(req)
+  dogId, _ := strconv.Atoi(vars["id"])
+  if _, ok := p.Dogs[dogId]; !ok {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return nil, false
+  }
+  cert := certificate.New(p, dogId, time.Now(), []byte(ctx.Config.Server.VerifySecret))
+  cert.VerifyUrl = verifyUrl(ctx, cert.Code)
+  return cert, true
+}
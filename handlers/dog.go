@@ -9,15 +9,35 @@ import (
 
   "bitbucket.org/Rusty1958/shakingdog/data"
   "bitbucket.org/Rusty1958/shakingdog/db"
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
 
   "github.com/gorilla/mux"
 )
 
 
 func DogHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  asOf, err := OptionalTime(params, "asOf", true)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid asOf")
+    return
+  }
+
   // get dog based on supplied ID
   vars := mux.Vars(req)
   dogId, _ := strconv.Atoi(vars["id"])
+  if len(asOf) > 0 {
+    if !checkAsOf(w, ctx, asOf, "DogHandler") {
+      return
+    }
+    dogAsOf(w, ctx, dogId, asOf)
+    return
+  }
   dog, err := db.GetDog(ctx.DBConn, dogId)
   if err == sql.ErrNoRows {
     SendErrorResponse(w, ErrNotFound, strconv.Itoa(dog.Id))
@@ -47,3 +67,28 @@ func DogHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
   })
   w.Write(data)
 }
+
+func dogAsOf(w http.ResponseWriter, ctx *Context, dogId int, asOf string) {
+  // the register as it was is rebuilt from history, so families come
+  // from the pedigree rather than the DB
+  p, err := pedigree.LoadAsOf(ctx.DBConn, asOf)
+  if err != nil {
+    log.Printf("ERROR: DogHandler: pedigree.LoadAsOf error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  dog, ok := p.Dogs[dogId]
+  if !ok {
+    SendErrorResponse(w, ErrNotFound, strconv.Itoa(dogId))
+    return
+  }
+  familyAsChild, familiesAsParent := p.DogFamilies(dogId)
+
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(data.DogReport{
+    Dog: dog,
+    FamilyAsChild: familyAsChild,
+    FamiliesAsParent: familiesAsParent,
+  })
+  w.Write(data)
+}
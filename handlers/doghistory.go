@@ -0,0 +1,61 @@
+package handlers
+
+import (
+  "database/sql"
+  "encoding/json"
+  "log"
+  "net/http"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/auth"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+
+  "github.com/gorilla/mux"
+)
+
+
+func DogHistoryHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // Okta JWT provides group membership info
+  oktaContext := req.Context()
+  groups := auth.GroupsFromContext(oktaContext)
+
+  // get dog based on supplied ID
+  vars := mux.Vars(req)
+  dogId, _ := strconv.Atoi(vars["id"])
+  dog, err := db.GetDog(ctx.DBConn, dogId)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return
+  } else if err != nil {
+    log.Printf("ERROR: DogHistoryHandler: GetDog error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // entries are linked by ID, so renames don't break the history
+  entries, err := db.GetDogAuditEntries(ctx.DBConn, dogId)
+  if err != nil {
+    log.Printf("ERROR: DogHistoryHandler: GetDogAuditEntries error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // as with the audit log, user changes are only shown if allowed
+  if !auth.IsUserAuditAdmin(groups) {
+    systemEntries := []data.AuditEntry{}
+    for _, entry := range entries {
+      if entry.Actor == "System" {
+        systemEntries = append(systemEntries, entry)
+      }
+    }
+    entries = systemEntries
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(data.DogHistory{
+    Dog: dog,
+    Entries: entries,
+  })
+  w.Write(data)
+}
@@ -3,6 +3,7 @@ package handlers
 import (
   "database/sql"
   "encoding/json"
+  "fmt"
   "log"
   "net/http"
 
@@ -12,8 +13,29 @@ import (
 
 
 func DogsHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
-  // fetch all dogs
-  dogs, err := db.GetDogs(ctx.DBConn)
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  asOf, err := OptionalTime(params, "asOf", true)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid asOf")
+    return
+  }
+
+  if len(asOf) > 0 && !checkAsOf(w, ctx, asOf, "DogsHandler") {
+    return
+  }
+
+  // fetch all dogs, either now or as they were
+  var dogs []data.Dog
+  if len(asOf) > 0 {
+    dogs, err = db.GetDogsAsOf(ctx.DBConn, asOf)
+  } else {
+    dogs, err = db.GetDogs(ctx.DBConn)
+  }
   if err == sql.ErrNoRows {
     dogs = []data.Dog{}
   } else if err != nil {
@@ -26,3 +48,24 @@ func DogsHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
   data, _ := json.Marshal(data.Dogs{dogs})
   w.Write(data)
 }
+
+func checkAsOf(w http.ResponseWriter, ctx *Context, asOf, caller string) bool {
+  // checks the history goes back far enough to show the register as of
+  // a time, having sent an error response if it doesn't, as otherwise
+  // missing history looks the same as an empty register
+  start, err := db.GetHistoryStart(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: %s: GetHistoryStart error - %v", caller, err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return false
+  }
+  if len(start) == 0 {
+    SendErrorResponse(w, ErrBadRequest, "No history for asOf")
+    return false
+  }
+  if asOf <= start {
+    SendErrorResponse(w, ErrBadRequest, fmt.Sprintf("asOf must be after %s, when history begins", start))
+    return false
+  }
+  return true
+}
@@ -3,6 +3,7 @@ package handlers
 var ErrDogExists = 1
 var ErrBothParentsNeeded = 2
 var ErrAlreadyParent = 3
+var ErrChangedSince = 4
 var ErrBadRequest = 400
 var ErrForbidden = 403
 var ErrNotFound = 404
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+  "encoding/csv"
+  "log"
+  "net/http"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/auth"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+// rows written between each flush to the client
+const exportFlushInterval = 100
+
+
+func ExportCsvHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // Okta JWT provides group membership info, if logged in
+  oktaContext := req.Context()
+  groups := auth.GroupsFromContext(oktaContext)
+  isAdmin := auth.IsSlemAdmin(groups)
+
+  // admins also get the infer override flags
+  header := []string{"id", "name", "gender", "slemstatus", "cecsstatus", "sire", "dam"}
+  if isAdmin {
+    header = append(header, "sleminferoverride", "cecsinferoverride")
+  }
+
+  // rows are streamed straight from the DB to the client
+  w.Header().Set("Content-Type", "text/csv")
+  w.Header().Set("Content-Disposition", "attachment; filename=\"register.csv\"")
+  writer := csv.NewWriter(w)
+  writer.Write(header)
+  count := 0
+  err := db.StreamRegister(ctx.DBConn, func(entry *data.RegisterEntry) error {
+    row := []string{
+      strconv.Itoa(entry.Dog.Id),
+      entry.Dog.Name,
+      entry.Dog.Gender,
+      entry.Dog.ShakingDogStatus,
+      entry.Dog.CecsStatus,
+      entry.SireName,
+      entry.DamName,
+    }
+    if isAdmin {
+      row = append(row,
+        strconv.FormatBool(entry.Dog.ShakingDogInferOverride),
+        strconv.FormatBool(entry.Dog.CecsInferOverride),
+      )
+    }
+    err := writer.Write(row)
+    if err != nil {
+      return err
+    }
+
+    count++
+    if count % exportFlushInterval == 0 {
+      writer.Flush()
+      if flusher, ok := w.(http.Flusher); ok {
+        flusher.Flush()
+      }
+    }
+    return writer.Error()
+  })
+  writer.Flush()
+
+  // headers are long gone, so the best we can do is log it
+  if err != nil {
+    log.Printf("ERROR: ExportCsvHandler: StreamRegister error - %v", err)
+  }
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+  "fmt"
+  "log"
+  "net/http"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
+
+  "github.com/gorilla/mux"
+)
+
+
+func ExportGedcomHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // families need the whole register in memory, so unlike the CSV
+  // export this can't be streamed
+  p, err := pedigree.Load(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: ExportGedcomHandler: pedigree.Load error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // all done
+  w.Header().Set("Content-Type", "text/vnd.familysearch.gedcom")
+  w.Header().Set("Content-Disposition", "attachment; filename=\"register.ged\"")
+  err = p.WriteGedcom(w, p.SortedIds())
+  if err != nil {
+    log.Printf("ERROR: ExportGedcomHandler: WriteGedcom error - %v", err)
+  }
+}
+
+func PedigreeGedcomHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  depth, err := OptionalInt(params, "depth", pedigree.DefaultKinshipDepth)
+  if err != nil || depth < 1 || depth > pedigree.MaxKinshipDepth {
+    SendErrorResponse(w, ErrBadRequest, "Invalid depth")
+    return
+  }
+
+  // ancestors come from the whole register
+  p, err := pedigree.Load(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: PedigreeGedcomHandler: pedigree.Load error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  vars := mux.Vars(req)
+  dogId, _ := strconv.Atoi(vars["id"])
+  if _, ok := p.Dogs[dogId]; !ok {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return
+  }
+
+  // all done
+  w.Header().Set("Content-Type", "text/vnd.familysearch.gedcom")
+  w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"pedigree-%d.ged\"", dogId))
+  err = p.WriteGedcom(w, p.PedigreeIds(dogId, depth))
+  if err != nil {
+    log.Printf("ERROR: PedigreeGedcomHandler: WriteGedcom error - %v", err)
+  }
+}
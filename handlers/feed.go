@@ -0,0 +1,111 @@
+package handlers
+
+import (
+  "database/sql"
+  "fmt"
+  "log"
+  "net/http"
+  "net/url"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+  "bitbucket.org/Rusty1958/shakingdog/feed"
+
+  "github.com/gorilla/mux"
+)
+
+// entries in a feed, unless asked for otherwise
+const (
+  defaultFeedLimit = 50
+  maxFeedLimit = 200
+)
+
+
+func ResultsFeedHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  filter := data.StatusChangeFilter{Statuses: feed.ResultStatuses}
+  filter.Limit, err = OptionalInt(params, "limit", defaultFeedLimit)
+  if err != nil || filter.Limit < 1 || filter.Limit > maxFeedLimit {
+    SendErrorResponse(w, ErrBadRequest, "Invalid limit")
+    return
+  }
+
+  // a kennel's dogs are those named with its prefix
+  title := "SLEM / CECS Register: Test results"
+  selfUrl := feedUrl(ctx, "/api/feed/results.atom")
+  if params["kennel"] != nil {
+    filter.NamePrefix = params["kennel"][0]
+    if len(filter.NamePrefix) == 0 {
+      SendErrorResponse(w, ErrBadRequest, "Invalid kennel")
+      return
+    }
+    title = fmt.Sprintf("%s for %s", title, filter.NamePrefix)
+    selfUrl = fmt.Sprintf("%s?kennel=%s", selfUrl, url.QueryEscape(filter.NamePrefix))
+  }
+  writeResultsFeed(w, ctx, &filter, title, selfUrl, "ResultsFeedHandler")
+}
+
+func DogResultsFeedHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  filter := data.StatusChangeFilter{Statuses: feed.ResultStatuses}
+  filter.Limit, err = OptionalInt(params, "limit", defaultFeedLimit)
+  if err != nil || filter.Limit < 1 || filter.Limit > maxFeedLimit {
+    SendErrorResponse(w, ErrBadRequest, "Invalid limit")
+    return
+  }
+
+  // get dog based on supplied ID
+  vars := mux.Vars(req)
+  filter.DogId, _ = strconv.Atoi(vars["id"])
+  dog, err := db.GetDog(ctx.DBConn, filter.DogId)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return
+  } else if err != nil {
+    log.Printf("ERROR: DogResultsFeedHandler: GetDog error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  title := fmt.Sprintf("SLEM / CECS Register: Test results for %s", dog.Name)
+  selfUrl := feedUrl(ctx, fmt.Sprintf("/api/dog/%d/results.atom", dog.Id))
+  writeResultsFeed(w, ctx, &filter, title, selfUrl, "DogResultsFeedHandler")
+}
+
+func writeResultsFeed(w http.ResponseWriter, ctx *Context, filter *data.StatusChangeFilter, title, selfUrl, caller string) {
+  // get matching status changes
+  changes, err := db.GetStatusChanges(ctx.DBConn, filter)
+  if err != nil {
+    log.Printf("ERROR: %s: GetStatusChanges error - %v", caller, err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // write feed
+  dogUrl := func(dogId int) string {
+    return feedUrl(ctx, fmt.Sprintf("/api/dog/%d", dogId))
+  }
+  w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+  err = feed.New(title, selfUrl, changes, dogUrl).Write(w)
+  if err != nil {
+    log.Printf("ERROR: %s: Write error - %v", caller, err)
+  }
+}
+
+func feedUrl(ctx *Context, path string) string {
+  return fmt.Sprintf("https://%s%s%s",
+    ctx.Config.Server.PublicHost,
+    ctx.Config.Server.BaseURL,
+    path,
+  )
+}
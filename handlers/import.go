@@ -0,0 +1,101 @@
+package handlers
+
+import (
+  "bytes"
+  "encoding/json"
+  "io/ioutil"
+  "log"
+  "net/http"
+
+  "bitbucket.org/Rusty1958/shakingdog/auth"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/importer"
+)
+
+// largest import file accepted, in bytes
+const maxImportSize = 10 << 20
+
+
+func ImportCsvHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  runImport(w, req, ctx, "CSV upload", "ImportCsvHandler", func(content []byte) ([]data.ImportRow, error) {
+    return importer.ReadCsv(bytes.NewReader(content))
+  })
+}
+
+func ImportXlsxHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  runImport(w, req, ctx, "spreadsheet upload", "ImportXlsxHandler", func(content []byte) ([]data.ImportRow, error) {
+    return importer.ReadXlsx(bytes.NewReader(content), int64(len(content)))
+  })
+}
+
+func ImportGedcomHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  runImport(w, req, ctx, "GEDCOM upload", "ImportGedcomHandler", func(content []byte) ([]data.ImportRow, error) {
+    return importer.ReadGedcom(bytes.NewReader(content))
+  })
+}
+
+func runImport(w http.ResponseWriter, req *http.Request, ctx *Context, source, name string, read func([]byte) ([]data.ImportRow, error)) {
+  // common handling for all import file formats
+
+  // get authorised user
+  oktaContext := req.Context()
+  username := auth.UsernameFromContext(oktaContext)
+
+  // a dry run reports what would happen without committing
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  dryRun := OptionalBool(params, "dryrun")
+
+  // parse POST body
+  content, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxImportSize))
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid body")
+    return
+  }
+  rows, err := read(content)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, err.Error())
+    return
+  }
+
+  // start Tx
+  txConn, err := ctx.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    log.Printf("ERROR: %s: Tx Begin error - %v", name, err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  defer txConn.Rollback()
+
+  // apply all rows
+  report, err := importer.Import(txConn, rows, username, dryRun)
+  if err != nil {
+    log.Printf("ERROR: %s: Import error - %v", name, err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // commit Tx, unless only previewing
+  if !dryRun {
+    err = importer.SaveAuditSummary(txConn, source, content, importer.Summary(report), username)
+    if err != nil {
+      log.Printf("ERROR: %s: SaveAuditSummary error - %v", name, err)
+      SendErrorResponse(w, ErrServerError, "Database error")
+      return
+    }
+    err = txConn.Commit()
+    if err != nil {
+      log.Printf("ERROR: %s: Tx Commit error - %v", name, err)
+      SendErrorResponse(w, ErrServerError, "Database error")
+      return
+    }
+  }
+
+  // all done
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(report)
+  w.Write(data)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+  "encoding/json"
+  "log"
+  "net/http"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
+)
+
+
+func KinshipHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  err = ExpectKeys(
+    params,
+    []string{"dogid1", "dogid2"},
+  )
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  dog1Id, err := strconv.Atoi(params["dogid1"][0])
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  dog2Id, err := strconv.Atoi(params["dogid2"][0])
+  if err != nil || dog2Id == dog1Id {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  depth, err := OptionalInt(params, "depth", pedigree.DefaultKinshipDepth)
+  if err != nil || depth < 1 || depth > pedigree.MaxKinshipDepth {
+    SendErrorResponse(w, ErrBadRequest, "Invalid depth")
+    return
+  }
+
+  // tracing needs the whole register
+  p, err := pedigree.Load(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: KinshipHandler: pedigree.Load error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  dog1, ok := p.Dogs[dog1Id]
+  if !ok {
+    SendErrorResponse(w, ErrNotFound, strconv.Itoa(dog1Id))
+    return
+  }
+  dog2, ok := p.Dogs[dog2Id]
+  if !ok {
+    SendErrorResponse(w, ErrNotFound, strconv.Itoa(dog2Id))
+    return
+  }
+
+  // lines of descent are only listed so far back, and too many are
+  // refused rather than listed
+  commonAncestors, err := p.CommonAncestors(dog1Id, dog2Id, depth)
+  if err == pedigree.ErrTooManyPaths {
+    SendErrorResponse(w, ErrBadRequest, "Too many lines of descent")
+    return
+  } else if err != nil {
+    log.Printf("ERROR: KinshipHandler: CommonAncestors error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Server error")
+    return
+  }
+
+  // all done
+  k := p.NewKinship(depth)
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(data.KinshipReport{
+    Dog1: dog1,
+    Dog2: dog2,
+    Depth: depth,
+    PathDepth: data.Min(depth, pedigree.MaxPathDepth),
+    Coancestry: k.Coancestry(dog1Id, dog2Id),
+    Relationship: k.Relationship(dog1Id, dog2Id),
+    CommonAncestors: commonAncestors,
+  })
+  w.Write(data)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+  "encoding/csv"
+  "encoding/json"
+  "fmt"
+  "log"
+  "net/http"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
+)
+
+// guards against runaway requests; breeding groups are 20 to 50 dogs
+const maxKinshipGroupSize = 200
+
+
+func KinshipMatrixHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  asCsv := params["format"] != nil && params["format"][0] == "csv"
+
+  // parse POST body
+  var group data.KinshipGroup
+  err = json.NewDecoder(req.Body).Decode(&group)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid body")
+    return
+  }
+  if len(group.DogIds) == 0 || len(group.DogIds) > maxKinshipGroupSize {
+    SendErrorResponse(w, ErrBadRequest, "Invalid group size")
+    return
+  }
+  if group.Depth == 0 {
+    group.Depth = pedigree.DefaultKinshipDepth
+  }
+  if group.Depth < 1 || group.Depth > pedigree.MaxKinshipDepth {
+    SendErrorResponse(w, ErrBadRequest, "Invalid depth")
+    return
+  }
+
+  // calculation needs the whole register
+  p, err := pedigree.Load(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: KinshipMatrixHandler: pedigree.Load error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  dogs := []data.Dog{}
+  for i, dogId := range group.DogIds {
+    dog, ok := p.Dogs[dogId]
+    if !ok {
+      SendErrorResponse(w, ErrNotFound, strconv.Itoa(dogId))
+      return
+    }
+    if data.IntInSlice(group.DogIds[:i], dogId) {
+      SendErrorResponse(w, ErrBadRequest, fmt.Sprintf("Duplicate dog %d", dogId))
+      return
+    }
+    dogs = append(dogs, dog)
+  }
+  matrix, meanKinship := p.NewKinship(group.Depth).Matrix(group.DogIds)
+
+  // spreadsheet-friendly output
+  if asCsv {
+    w.Header().Set("Content-Type", "text/csv")
+    w.Header().Set("Content-Disposition", "attachment; filename=\"kinship.csv\"")
+    writer := csv.NewWriter(w)
+    header := []string{"id", "name"}
+    for _, dog := range dogs {
+      header = append(header, dog.Name)
+    }
+    writer.Write(append(header, "meankinship"))
+    for i, dog := range dogs {
+      row := []string{strconv.Itoa(dog.Id), dog.Name}
+      for _, f := range matrix[i] {
+        row = append(row, strconv.FormatFloat(f, 'f', 6, 64))
+      }
+      writer.Write(append(row, strconv.FormatFloat(meanKinship[i], 'f', 6, 64)))
+    }
+    writer.Flush()
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(data.KinshipMatrix{
+    Dogs: dogs,
+    Depth: group.Depth,
+    Matrix: matrix,
+    MeanKinship: meanKinship,
+  })
+  w.Write(data)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+  "bytes"
+  "encoding/json"
+  "io/ioutil"
+  "log"
+  "net/http"
+
+  "bitbucket.org/Rusty1958/shakingdog/auth"
+  "bitbucket.org/Rusty1958/shakingdog/importer"
+)
+
+
+func ImportLabResultsHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // get authorised user
+  oktaContext := req.Context()
+  username := auth.UsernameFromContext(oktaContext)
+
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  err = ExpectKeys(
+    params,
+    []string{"lab"},
+  )
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Missing lab")
+    return
+  }
+  lab := ctx.Config.Lab(params["lab"][0])
+  if lab == nil {
+    SendErrorResponse(w, ErrBadRequest, "Unknown lab")
+    return
+  }
+  dryRun := OptionalBool(params, "dryrun")
+
+  // parse POST body
+  content, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxImportSize))
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid body")
+    return
+  }
+  results, err := importer.ReadLabCsv(bytes.NewReader(content), lab)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, err.Error())
+    return
+  }
+
+  // start Tx
+  txConn, err := ctx.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    log.Printf("ERROR: ImportLabResultsHandler: Tx Begin error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  defer txConn.Rollback()
+
+  // apply all results that can be matched
+  report, err := importer.ImportLabResults(txConn, lab, results, username, dryRun)
+  if err != nil {
+    log.Printf("ERROR: ImportLabResultsHandler: ImportLabResults error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // commit Tx, unless only previewing
+  if !dryRun {
+    err = importer.SaveAuditSummary(txConn, "lab report from '" + lab.Name + "'", content, importer.LabSummary(report), username)
+    if err != nil {
+      log.Printf("ERROR: ImportLabResultsHandler: SaveAuditSummary error - %v", err)
+      SendErrorResponse(w, ErrServerError, "Database error")
+      return
+    }
+    err = txConn.Commit()
+    if err != nil {
+      log.Printf("ERROR: ImportLabResultsHandler: Tx Commit error - %v", err)
+      SendErrorResponse(w, ErrServerError, "Database error")
+      return
+    }
+  }
+
+  // all done
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(report)
+  w.Write(data)
+}
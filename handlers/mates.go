@@ -0,0 +1,66 @@
+package handlers
+
+import (
+  "encoding/json"
+  "log"
+  "net/http"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
+
+  "github.com/gorilla/mux"
+)
+
+
+func MatesHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  depth, err := OptionalInt(params, "depth", pedigree.DefaultKinshipDepth)
+  if err != nil || depth < 1 || depth > pedigree.MaxKinshipDepth {
+    SendErrorResponse(w, ErrBadRequest, "Invalid depth")
+    return
+  }
+  limit, err := OptionalInt(params, "limit", 0)
+  if err != nil || limit < 0 {
+    SendErrorResponse(w, ErrBadRequest, "Invalid limit")
+    return
+  }
+
+  // ranking needs the whole register
+  p, err := pedigree.Load(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: MatesHandler: pedigree.Load error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // get dog based on supplied ID
+  vars := mux.Vars(req)
+  dogId, _ := strconv.Atoi(vars["id"])
+  dog, ok := p.Dogs[dogId]
+  if !ok {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return
+  }
+  if dog.Gender != "D" && dog.Gender != "B" {
+    SendErrorResponse(w, ErrBadRequest, "Unknown gender")
+    return
+  }
+
+  // all done
+  candidates := p.SuggestMates(dogId, params["slemstatus"], params["cecsstatus"], depth)
+  if limit > 0 && len(candidates) > limit {
+    candidates = candidates[:limit]
+  }
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(data.MateSuggestions{
+    Dog: dog,
+    Candidates: candidates,
+  })
+  w.Write(data)
+}
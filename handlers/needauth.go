@@ -10,14 +10,14 @@ import (
 
 
 func NeedAuthHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
-  // Invoked whenever a secured handler needs Okta auth
+  // Invoked whenever a secured handler needs auth
 
   // tell API caller to redirect to the app login path
   data, _ := json.Marshal(data.Redirect{
     Location: fmt.Sprintf("https://%s%s%s",
       ctx.Config.Server.PublicHost,
       ctx.Config.Server.BaseURL,
-      ctx.Config.Okta.LoginPath),
+      ctx.Config.Auth().LoginPath),
   })
   w.Header().Set("Content-Type", "application/json")
   w.Write(data)
@@ -1,13 +1,16 @@
 package handlers
 
 import (
+  "database/sql"
   "encoding/json"
+  "fmt"
   "log"
   "net/http"
 
   "bitbucket.org/Rusty1958/shakingdog/auth"
   "bitbucket.org/Rusty1958/shakingdog/data"
   "bitbucket.org/Rusty1958/shakingdog/db"
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
 )
 
 
@@ -16,10 +19,24 @@ func NewLitterHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
   oktaContext := req.Context()
   username := auth.UsernameFromContext(oktaContext)
 
+  // a dry run does everything except commit, and reports problems
+  // instead of stopping at the first one
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  dryRun := OptionalBool(params, "dryrun")
+  preview := data.LitterPreview{
+    Created: []data.Dog{},
+    Matched: []data.Dog{},
+    Problems: []string{},
+  }
+
   // parse POST body
   decoder := json.NewDecoder(req.Body)
   var newLitter data.NewLitter
-  err := decoder.Decode(&newLitter)
+  err = decoder.Decode(&newLitter)
   if err != nil {
     SendErrorResponse(w, ErrBadRequest, "Invalid body")
     return
@@ -40,30 +57,67 @@ func NewLitterHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
     entries = append(entries, &newLitter.Children[i])
   }
   for _, dog := range entries {
-    if dog.Id == 0 {
-      // is dog request valid?
-      if !data.IsValidDog(dog) {
-        SendErrorResponse(w, ErrBadRequest, "Invalid body")
-        return
+    if dog.Id != 0 {
+      // preview shows the stored details of existing dogs
+      if dryRun {
+        existing, err := db.GetDog(txConn, dog.Id)
+        if err == sql.ErrNoRows {
+          preview.Problems = append(preview.Problems, fmt.Sprintf("Dog %d not found", dog.Id))
+          dog.Id = 0
+          continue
+        } else if err != nil {
+          log.Printf("ERROR: NewLitterHandler: GetDog error - %v", err)
+          SendErrorResponse(w, ErrServerError, "Database error")
+          return
+        }
+        *dog = existing
+        preview.Matched = append(preview.Matched, existing)
       }
+      continue
+    }
+
+    // is dog request valid?
+    if !data.IsValidDog(dog) {
+      if dryRun {
+        preview.Problems = append(preview.Problems, fmt.Sprintf("Invalid details for '%s'", dog.Name))
+        continue
+      }
+      SendErrorResponse(w, ErrBadRequest, "Invalid body")
+      return
+    }
 
-      // seems valid, so create dog
-      err = db.SaveNewDog(txConn, dog, username)
-      if err == db.ErrUniqueViolation {
-        SendErrorResponse(w, ErrDogExists, dog.Name)
-        return
-      } else if err != nil {
-        log.Printf("ERROR: NewLitterHandler: SaveNewDog error - %v", err)
-        SendErrorResponse(w, ErrServerError, "Database error")
-        return
+    // seems valid, so create dog
+    err = db.SaveNewDog(txConn, dog, username)
+    if err == db.ErrUniqueViolation {
+      if dryRun {
+        existing, err := db.GetDogByName(txConn, dog.Name)
+        if err != nil {
+          log.Printf("ERROR: NewLitterHandler: GetDogByName error - %v", err)
+          SendErrorResponse(w, ErrServerError, "Database error")
+          return
+        }
+        preview.Matched = append(preview.Matched, existing)
+        preview.Problems = append(preview.Problems, fmt.Sprintf("'%s' already exists", dog.Name))
+        continue
       }
+      SendErrorResponse(w, ErrDogExists, dog.Name)
+      return
+    } else if err != nil {
+      log.Printf("ERROR: NewLitterHandler: SaveNewDog error - %v", err)
+      SendErrorResponse(w, ErrServerError, "Database error")
+      return
     }
+    preview.Created = append(preview.Created, *dog)
   }
-  
+
   // THEN, create relationships
+  // NOTE: a dry run skips any dog that could not be created
   sireId := entries[0].Id
   damId := entries[1].Id
   for _, child := range entries[2:] {
+    if sireId == 0 || damId == 0 || child.Id == 0 {
+      continue
+    }
     err = db.SaveRelationship(txConn, sireId, damId, child.Id, username)
     if err != nil {
       log.Printf("ERROR: NewLitterHandler: SaveNewRelationship error - %v", err)
@@ -72,6 +126,15 @@ func NewLitterHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
     }
   }
 
+  // a dry run stops here, and the deferred rollback discards everything
+  if dryRun {
+    preview.Inferred = pedigree.InferLitter(newLitter.Sire, newLitter.Dam, newLitter.Children)
+    w.Header().Set("Content-Type", "application/json")
+    data, _ := json.Marshal(preview)
+    w.Write(data)
+    return
+  }
+
   // commit Tx
   err = txConn.Commit()
   if err != nil {
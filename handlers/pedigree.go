@@ -0,0 +1,66 @@
+package handlers
+
+import (
+  "log"
+  "net/http"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/pedigree"
+
+  "github.com/gorilla/mux"
+)
+
+
+func PedigreeDotHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  graph, ok := pedigreeGraph(w, req, ctx, "PedigreeDotHandler")
+  if !ok {
+    return
+  }
+  w.Header().Set("Content-Type", "text/vnd.graphviz")
+  err := graph.WriteDot(w)
+  if err != nil {
+    log.Printf("ERROR: PedigreeDotHandler: WriteDot error - %v", err)
+  }
+}
+
+func PedigreeSvgHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  graph, ok := pedigreeGraph(w, req, ctx, "PedigreeSvgHandler")
+  if !ok {
+    return
+  }
+  w.Header().Set("Content-Type", "image/svg+xml")
+  err := graph.WriteSvg(w)
+  if err != nil {
+    log.Printf("ERROR: PedigreeSvgHandler: WriteSvg error - %v", err)
+  }
+}
+
+func pedigreeGraph(w http.ResponseWriter, req *http.Request, ctx *Context, caller string) (*pedigree.Graph, bool) {
+  // builds the graph for the dog in the URL, having sent an error
+  // response if that's not possible
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return nil, false
+  }
+  depth, err := OptionalInt(params, "depth", pedigree.DefaultGraphDepth)
+  if err != nil || depth < 1 || depth > pedigree.MaxGraphDepth {
+    SendErrorResponse(w, ErrBadRequest, "Invalid depth")
+    return nil, false
+  }
+
+  // ancestors and descendants come from the whole register
+  p, err := pedigree.Load(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: %s: pedigree.Load error - %v", caller, err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return nil, false
+  }
+  vars := mux.Vars(req)
+  dogId, _ := strconv.Atoi(vars["id"])
+  if _, ok := p.Dogs[dogId]; !ok {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return nil, false
+  }
+  return p.Graph(dogId, depth), true
+}
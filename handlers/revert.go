@@ -0,0 +1,77 @@
+package handlers
+
+import (
+  "database/sql"
+  "log"
+  "net/http"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/auth"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+
+  "github.com/gorilla/mux"
+)
+
+
+func RevertHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // get authorised user
+  oktaContext := req.Context()
+  username := auth.UsernameFromContext(oktaContext)
+  groups := auth.GroupsFromContext(oktaContext)
+
+  // start Tx
+  txConn, err := ctx.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    log.Printf("ERROR: RevertHandler: Tx Begin error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  defer txConn.Rollback()
+
+  // get audit entry based on supplied ID
+  vars := mux.Vars(req)
+  entryId, _ := strconv.Atoi(vars["id"])
+  entry, err := db.GetAuditEntry(txConn, entryId)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return
+  } else if err != nil {
+    log.Printf("ERROR: RevertHandler: GetAuditEntry error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // user changes can only be reverted by those allowed to see them
+  if entry.Actor != "System" && !auth.IsUserAuditAdmin(groups) {
+    SendErrorResponse(w, ErrForbidden, "Not allowed")
+    return
+  }
+
+  // apply inverse change
+  err = db.RevertAuditEntry(txConn, &entry, username)
+  if err == db.ErrNotRevertible {
+    SendErrorResponse(w, ErrBadRequest, "Change cannot be reverted")
+    return
+  } else if err == db.ErrRevertConflict {
+    SendErrorResponse(w, ErrChangedSince, vars["id"])
+    return
+  } else if err == db.ErrUniqueViolation {
+    SendErrorResponse(w, ErrDogExists, entry.Action)
+    return
+  } else if err != nil {
+    log.Printf("ERROR: RevertHandler: RevertAuditEntry error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // commit Tx
+  err = txConn.Commit()
+  if err != nil {
+    log.Printf("ERROR: RevertHandler: Tx Commit error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // all done
+  SendSuccessResponse(w, nil)
+}
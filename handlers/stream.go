@@ -0,0 +1,132 @@
+package handlers
+
+import (
+  "encoding/json"
+  "fmt"
+  "log"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/auth"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+const (
+  // how often an idle stream is written to, so proxies keep it open
+  streamHeartbeat = 30 * time.Second
+  // how long a client waits before reconnecting, in milliseconds
+  streamRetry = 5000
+  // events caught up on per query after a reconnect
+  streamReplayBatch = 500
+)
+
+
+func StreamHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // Okta JWT provides group membership info
+  oktaContext := req.Context()
+  groups := auth.GroupsFromContext(oktaContext)
+  userAuditAdmin := auth.IsUserAuditAdmin(groups)
+
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  types := []string{}
+  if params["types"] != nil {
+    for _, eventType := range strings.Split(params["types"][0], ",") {
+      if !data.StringInSlice(db.EventTypes, eventType) {
+        SendErrorResponse(w, ErrBadRequest, "Invalid types")
+        return
+      }
+      types = append(types, eventType)
+    }
+  }
+
+  // reconnecting clients say where they got up to, either as a header
+  // or (for EventSource polyfills) as a query param
+  lastEventId := req.Header.Get("Last-Event-ID")
+  if len(lastEventId) == 0 && params["lastEventId"] != nil {
+    lastEventId = params["lastEventId"][0]
+  }
+  afterId := -1
+  if len(lastEventId) > 0 {
+    afterId, err = strconv.Atoi(lastEventId)
+    if err != nil || afterId < 0 {
+      SendErrorResponse(w, ErrBadRequest, "Invalid Last-Event-ID")
+      return
+    }
+  }
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    log.Printf("ERROR: StreamHandler: Streaming not supported")
+    SendErrorResponse(w, ErrServerError, "Streaming not supported")
+    return
+  }
+
+  // subscribe before catching up, so nothing falls between the two
+  events, liveFromId := ctx.Broker.Subscribe()
+  defer ctx.Broker.Unsubscribe(events)
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.Header().Set("Connection", "keep-alive")
+  w.Header().Set("X-Accel-Buffering", "no")
+  fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+  send := func(event data.Event) {
+    if len(types) > 0 && !data.StringInSlice(types, event.Type) {
+      return
+    }
+    // who made a change is only shown to those allowed to see it
+    if event.Entry.Actor != "System" && !userAuditAdmin {
+      event.Entry.Actor = ""
+    }
+    content, _ := json.Marshal(event)
+    fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, content)
+  }
+
+  // catch up on anything missed since the last event received
+catchUp:
+  for afterId >= 0 && afterId < liveFromId {
+    missed, err := db.GetEventsSince(ctx.DBConn, afterId, streamReplayBatch)
+    if err != nil {
+      log.Printf("ERROR: StreamHandler: GetEventsSince error - %v", err)
+      return
+    }
+    if len(missed) == 0 {
+      break
+    }
+    for _, event := range missed {
+      if event.Id > liveFromId {
+        break catchUp
+      }
+      send(event)
+      afterId = event.Id
+    }
+    if len(missed) < streamReplayBatch {
+      break
+    }
+  }
+  flusher.Flush()
+
+  // then stream live until the client goes, or is too slow to keep up
+  heartbeat := time.NewTicker(streamHeartbeat)
+  defer heartbeat.Stop()
+  for {
+    select {
+    case <-oktaContext.Done():
+      return
+    case event, ok := <-events:
+      if !ok {
+        return
+      }
+      send(event)
+    case <-heartbeat.C:
+      fmt.Fprintf(w, ": heartbeat\n\n")
+    }
+    flusher.Flush()
+  }
+}
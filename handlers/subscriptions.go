@@ -0,0 +1,257 @@
+package handlers
+
+import (
+  "crypto/rand"
+  "database/sql"
+  "encoding/hex"
+  "encoding/json"
+  "log"
+  "net/http"
+  "net/mail"
+  "strconv"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/auth"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+  "bitbucket.org/Rusty1958/shakingdog/notify"
+
+  "github.com/gorilla/mux"
+)
+
+
+func SubscriptionsHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // get authorised user
+  oktaContext := req.Context()
+  username := auth.UsernameFromContext(oktaContext)
+
+  // get user's subscriptions
+  subscriptions, err := db.GetSubscriptions(ctx.DBConn, username)
+  if err != nil {
+    log.Printf("ERROR: SubscriptionsHandler: GetSubscriptions error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // marshal and send response
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(data.Subscriptions{Subscriptions: subscriptions})
+  w.Write(data)
+}
+
+func NewSubscriptionHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // get authorised user
+  oktaContext := req.Context()
+  username := auth.UsernameFromContext(oktaContext)
+
+  // parse POST body
+  decoder := json.NewDecoder(req.Body)
+  var subscription data.Subscription
+  err := decoder.Decode(&subscription)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid body")
+    return
+  }
+
+  // emails only go to the verified address of the user's login, so
+  // that nobody can be subscribed without asking
+  email := auth.EmailFromContext(oktaContext)
+  if len(email) == 0 {
+    SendErrorResponse(w, ErrForbidden, "No verified email for login")
+    return
+  }
+  if len(subscription.Email) > 0 {
+    address, err := mail.ParseAddress(subscription.Email)
+    if err != nil {
+      SendErrorResponse(w, ErrBadRequest, "Invalid email")
+      return
+    }
+    if !strings.EqualFold(address.Address, email) {
+      SendErrorResponse(w, ErrForbidden, "Email must be the login's")
+      return
+    }
+  }
+  subscription.Email = email
+
+  // start Tx
+  txConn, err := ctx.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    log.Printf("ERROR: NewSubscriptionHandler: Tx Begin error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  defer txConn.Rollback()
+
+  // check dog exists
+  subscription.Dog, err = db.GetDog(txConn, subscription.Dog.Id)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, strconv.Itoa(subscription.Dog.Id))
+    return
+  } else if err != nil {
+    log.Printf("ERROR: NewSubscriptionHandler: GetDog error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // create subscription, with a token for unsubscribing from an email
+  token := make([]byte, 16)
+  _, err = rand.Read(token)
+  if err != nil {
+    log.Printf("ERROR: NewSubscriptionHandler: Token generate error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Token error")
+    return
+  }
+  err = db.SaveSubscription(txConn, &subscription, username, hex.EncodeToString(token))
+  if err != nil {
+    log.Printf("ERROR: NewSubscriptionHandler: SaveSubscription error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // commit Tx
+  err = txConn.Commit()
+  if err != nil {
+    log.Printf("ERROR: NewSubscriptionHandler: Tx Commit error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // marshal and send response
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(subscription)
+  w.Write(data)
+}
+
+func DeleteSubscriptionHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // get authorised user
+  oktaContext := req.Context()
+  username := auth.UsernameFromContext(oktaContext)
+
+  // start Tx
+  txConn, err := ctx.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    log.Printf("ERROR: DeleteSubscriptionHandler: Tx Begin error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  defer txConn.Rollback()
+
+  // remove the user's subscription based on supplied ID
+  vars := mux.Vars(req)
+  subscriptionId, _ := strconv.Atoi(vars["id"])
+  err = db.DeleteSubscription(txConn, subscriptionId, username)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return
+  } else if err != nil {
+    log.Printf("ERROR: DeleteSubscriptionHandler: DeleteSubscription error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // commit Tx
+  err = txConn.Commit()
+  if err != nil {
+    log.Printf("ERROR: DeleteSubscriptionHandler: Tx Commit error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // all done
+  SendSuccessResponse(w, nil)
+}
+
+func UnsubscribeHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // shows the subscription an unsubscribe link was sent for, with a
+  // button to confirm, as links are followed by mail scanners too
+  token, ok := unsubscribeToken(w, req)
+  if !ok {
+    return
+  }
+  subscription, err := db.GetSubscriptionByToken(ctx.DBConn, token)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, "Already unsubscribed")
+    return
+  } else if err != nil {
+    log.Printf("ERROR: UnsubscribeHandler: GetSubscriptionByToken error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  err = notify.WriteUnsubscribeHtml(w, &subscription, false)
+  if err != nil {
+    log.Printf("ERROR: UnsubscribeHandler: WriteUnsubscribeHtml error - %v", err)
+  }
+}
+
+func ConfirmUnsubscribeHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // removes the subscription an unsubscribe link was sent for, either
+  // from the confirmation page or by mail clients' one-click unsubscribe
+  token, ok := unsubscribeToken(w, req)
+  if !ok {
+    return
+  }
+
+  // start Tx
+  txConn, err := ctx.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    log.Printf("ERROR: ConfirmUnsubscribeHandler: Tx Begin error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  defer txConn.Rollback()
+
+  // remove the subscription the link was sent for
+  subscription, err := db.GetSubscriptionByToken(txConn, token)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, "Already unsubscribed")
+    return
+  } else if err != nil {
+    log.Printf("ERROR: ConfirmUnsubscribeHandler: GetSubscriptionByToken error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  err = db.DeleteSubscriptionByToken(txConn, token)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, "Already unsubscribed")
+    return
+  } else if err != nil {
+    log.Printf("ERROR: ConfirmUnsubscribeHandler: DeleteSubscriptionByToken error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // commit Tx
+  err = txConn.Commit()
+  if err != nil {
+    log.Printf("ERROR: ConfirmUnsubscribeHandler: Tx Commit error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // all done
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  err = notify.WriteUnsubscribeHtml(w, &subscription, true)
+  if err != nil {
+    log.Printf("ERROR: ConfirmUnsubscribeHandler: WriteUnsubscribeHtml error - %v", err)
+  }
+}
+
+func unsubscribeToken(w http.ResponseWriter, req *http.Request) (string, bool) {
+  // the token from an unsubscribe link, having sent an error response
+  // if there isn't one
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return "", false
+  }
+  err = ExpectKeys(
+    params,
+    []string{"token"},
+  )
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Missing token")
+    return "", false
+  }
+  return params["token"][0], true
+}
@@ -4,10 +4,12 @@ import (
   "bitbucket.org/Rusty1958/shakingdog/auth"
   "bitbucket.org/Rusty1958/shakingdog/config"
   "bitbucket.org/Rusty1958/shakingdog/db"
+  "bitbucket.org/Rusty1958/shakingdog/stream"
 )
 
 type Context struct {
   Config *config.Config
   DBConn *db.Connection
-  Okta *auth.Okta
+  Auth *auth.OIDC
+  Broker *stream.Broker
 }
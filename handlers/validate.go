@@ -3,6 +3,8 @@ package handlers
 import (
   "errors"
   "fmt"
+  "strconv"
+  "time"
 )
 
 
@@ -20,3 +22,39 @@ func ExpectKeys(params map[string][]string, expectedKeys []string) (error) {
 
   return nil
 }
+
+func OptionalInt(params map[string][]string, key string, defaultValue int) (int, error) {
+  // Returns the integer value of an optional parameter, or the
+  // default value if it was not supplied
+  v := params[key]
+  if v == nil {
+    return defaultValue, nil
+  }
+  return strconv.Atoi(v[0])
+}
+
+func OptionalBool(params map[string][]string, key string) (bool) {
+  // Returns true only if an optional parameter was supplied as "true"
+  v := params[key]
+  return v != nil && v[0] == "true"
+}
+
+func OptionalTime(params map[string][]string, key string, isEnd bool) (string, error) {
+  // Returns an optional date or full timestamp in the form the DB
+  // expects, where an end date is the start of the following day
+  v := params[key]
+  if v == nil {
+    return "", nil
+  }
+  stamp, err := time.Parse(time.RFC3339, v[0])
+  if err != nil {
+    stamp, err = time.ParseInLocation("2006-01-02", v[0], time.Local)
+    if err != nil {
+      return "", err
+    }
+    if isEnd {
+      stamp = stamp.AddDate(0, 0, 1)
+    }
+  }
+  return stamp.Local().Format("2006-01-02 15:04:05"), nil
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+  "database/sql"
+  "encoding/json"
+  "fmt"
+  "log"
+  "net/http"
+  "net/url"
+  "strconv"
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/certificate"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+
+  "github.com/gorilla/mux"
+)
+
+// longest a verification link can be made to last, in days
+const maxVerificationDays = 3650
+
+
+func VerificationLinkHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  days, err := OptionalInt(params, "expires", 0)
+  if err != nil || days < 0 || days > maxVerificationDays {
+    SendErrorResponse(w, ErrBadRequest, "Invalid expires")
+    return
+  }
+
+  // get dog based on supplied ID
+  vars := mux.Vars(req)
+  dogId, _ := strconv.Atoi(vars["id"])
+  dog, err := db.GetDog(ctx.DBConn, dogId)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return
+  } else if err != nil {
+    log.Printf("ERROR: VerificationLinkHandler: GetDog error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // sign the dog's statuses as they are now
+  issued := time.Now()
+  link := data.VerificationLink{
+    Dog: dog,
+    Issued: issued.Format("2006-01-02"),
+  }
+  var expires time.Time
+  if days > 0 {
+    expires = issued.AddDate(0, 0, days)
+    link.Expires = expires.Format("2006-01-02")
+  }
+  link.Code = certificate.Sign([]byte(ctx.Config.Server.VerifySecret), dog, issued, expires)
+  link.Url = verifyUrl(ctx, link.Code)
+
+  // all done
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(link)
+  w.Write(data)
+}
+
+func VerifyHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  err = ExpectKeys(
+    params,
+    []string{"code"},
+  )
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Missing code")
+    return
+  }
+  verification := data.Verification{Code: params["code"][0]}
+
+  // a code that can't be parsed, or is for a dog no longer in the
+  // register, is simply not valid
+  code, err := certificate.ParseCode(verification.Code)
+  if err != nil {
+    verification.Message = "Not a verification code"
+    sendVerification(w, &verification)
+    return
+  }
+  verification.Issued = code.Issued.Format("2006-01-02")
+  if !code.Expires.IsZero() {
+    verification.Expires = code.Expires.Format("2006-01-02")
+  }
+  dog, err := db.GetDog(ctx.DBConn, code.DogId)
+  if err == sql.ErrNoRows {
+    verification.Message = "Dog is not in the register"
+    sendVerification(w, &verification)
+    return
+  } else if err != nil {
+    log.Printf("ERROR: VerifyHandler: GetDog error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // the signature covers what the register held when the code was
+  // issued, so any change since then stops it matching
+  verification.Expired = code.Expired(time.Now())
+  switch {
+  case !code.Matches([]byte(ctx.Config.Server.VerifySecret), dog):
+    verification.Message = "Code does not match the register, the dog's details may have changed since it was issued"
+  case verification.Expired:
+    verification.Message = "Code has expired"
+  default:
+    verification.Valid = true
+    verification.Dog = &dog
+    verification.Message = "Status matches the register"
+  }
+  sendVerification(w, &verification)
+}
+
+func sendVerification(w http.ResponseWriter, verification *data.Verification) {
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(verification)
+  w.Write(data)
+}
+
+func verifyUrl(ctx *Context, code string) string {
+  return fmt.Sprintf("https://%s%s/api/verify?code=%s",
+    ctx.Config.Server.PublicHost,
+    ctx.Config.Server.BaseURL,
+    url.QueryEscape(code),
+  )
+}
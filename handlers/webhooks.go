@@ -0,0 +1,229 @@
+package handlers
+
+import (
+  "crypto/rand"
+  "database/sql"
+  "encoding/hex"
+  "encoding/json"
+  "log"
+  "net/http"
+  "strconv"
+
+  "bitbucket.org/Rusty1958/shakingdog/auth"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+  "bitbucket.org/Rusty1958/shakingdog/webhook"
+
+  "github.com/gorilla/mux"
+)
+
+// deliveries returned per page, unless asked for otherwise
+const (
+  defaultDeliveryLimit = 50
+  maxDeliveryLimit = 500
+)
+
+
+func WebhooksHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // get all webhooks
+  hooks, err := db.GetWebhooks(ctx.DBConn)
+  if err != nil {
+    log.Printf("ERROR: WebhooksHandler: GetWebhooks error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // marshal and send response
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(data.Webhooks{Webhooks: hooks})
+  w.Write(data)
+}
+
+func NewWebhookHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // get authorised user
+  oktaContext := req.Context()
+  username := auth.UsernameFromContext(oktaContext)
+
+  // parse POST body
+  decoder := json.NewDecoder(req.Body)
+  var hook data.Webhook
+  err := decoder.Decode(&hook)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid body")
+    return
+  }
+
+  // is webhook request valid?
+  // NOTE: receivers at internal addresses are refused, so that hooks
+  //       can't be used to reach services behind the firewall
+  err = webhook.CheckUrl(req.Context(), hook.Url)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid url")
+    return
+  }
+  if len(hook.Events) == 0 {
+    SendErrorResponse(w, ErrBadRequest, "Invalid events")
+    return
+  }
+  for _, event := range hook.Events {
+    if !data.StringInSlice(db.EventTypes, event) {
+      SendErrorResponse(w, ErrBadRequest, "Invalid events")
+      return
+    }
+  }
+
+  // a secret is generated unless the receiver already has one
+  if len(hook.Secret) == 0 {
+    secret := make([]byte, 32)
+    _, err = rand.Read(secret)
+    if err != nil {
+      log.Printf("ERROR: NewWebhookHandler: Secret generate error - %v", err)
+      SendErrorResponse(w, ErrServerError, "Secret error")
+      return
+    }
+    hook.Secret = hex.EncodeToString(secret)
+  }
+
+  // start Tx
+  txConn, err := ctx.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    log.Printf("ERROR: NewWebhookHandler: Tx Begin error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  defer txConn.Rollback()
+
+  // create webhook
+  err = db.SaveWebhook(txConn, &hook, username)
+  if err != nil {
+    log.Printf("ERROR: NewWebhookHandler: SaveWebhook error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // commit Tx
+  err = txConn.Commit()
+  if err != nil {
+    log.Printf("ERROR: NewWebhookHandler: Tx Commit error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // marshal and send response, which is the only time the secret is
+  // ever sent back
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(hook)
+  w.Write(data)
+}
+
+func DeleteWebhookHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // get authorised user
+  oktaContext := req.Context()
+  username := auth.UsernameFromContext(oktaContext)
+
+  // start Tx
+  txConn, err := ctx.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    log.Printf("ERROR: DeleteWebhookHandler: Tx Begin error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+  defer txConn.Rollback()
+
+  // deactivate webhook based on supplied ID
+  vars := mux.Vars(req)
+  hookId, _ := strconv.Atoi(vars["id"])
+  err = db.DeleteWebhook(txConn, hookId, username)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return
+  } else if err != nil {
+    log.Printf("ERROR: DeleteWebhookHandler: DeleteWebhook error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // commit Tx
+  err = txConn.Commit()
+  if err != nil {
+    log.Printf("ERROR: DeleteWebhookHandler: Tx Commit error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // all done
+  SendSuccessResponse(w, nil)
+}
+
+func WebhookDeliveriesHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // validate query params
+  params, err := ParseAndUnescape(req.URL.RawQuery)
+  if err != nil {
+    SendErrorResponse(w, ErrBadRequest, "Invalid query")
+    return
+  }
+  limit, err := OptionalInt(params, "limit", defaultDeliveryLimit)
+  if err != nil || limit < 1 || limit > maxDeliveryLimit {
+    SendErrorResponse(w, ErrBadRequest, "Invalid limit")
+    return
+  }
+  cursor, err := OptionalInt(params, "cursor", 0)
+  if err != nil || cursor < 0 {
+    SendErrorResponse(w, ErrBadRequest, "Invalid cursor")
+    return
+  }
+
+  // get webhook based on supplied ID
+  vars := mux.Vars(req)
+  hookId, _ := strconv.Atoi(vars["id"])
+  _, err = db.GetWebhook(ctx.DBConn, hookId)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, vars["id"])
+    return
+  } else if err != nil {
+    log.Printf("ERROR: WebhookDeliveriesHandler: GetWebhook error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // get page of delivery log
+  deliveries, err := db.GetWebhookDeliveries(ctx.DBConn, hookId, cursor, limit)
+  if err != nil {
+    log.Printf("ERROR: WebhookDeliveriesHandler: GetWebhookDeliveries error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // a full page means there may be more deliveries to fetch
+  nextCursor := ""
+  if len(deliveries) == limit {
+    nextCursor = strconv.Itoa(deliveries[len(deliveries) - 1].Id)
+  }
+
+  // marshal and send response
+  w.Header().Set("Content-Type", "application/json")
+  data, _ := json.Marshal(data.WebhookDeliveries{
+    Deliveries: deliveries,
+    NextCursor: nextCursor,
+  })
+  w.Write(data)
+}
+
+func RetryWebhookDeliveryHandler(w http.ResponseWriter, req *http.Request, ctx *Context) {
+  // queue failed delivery based on supplied IDs
+  vars := mux.Vars(req)
+  hookId, _ := strconv.Atoi(vars["id"])
+  deliveryId, _ := strconv.Atoi(vars["deliveryid"])
+  err := db.RetryWebhookDelivery(ctx.DBConn, hookId, deliveryId)
+  if err == sql.ErrNoRows {
+    SendErrorResponse(w, ErrNotFound, vars["deliveryid"])
+    return
+  } else if err != nil {
+    log.Printf("ERROR: RetryWebhookDeliveryHandler: RetryWebhookDelivery error - %v", err)
+    SendErrorResponse(w, ErrServerError, "Database error")
+    return
+  }
+
+  // all done
+  SendSuccessResponse(w, nil)
+}
@@ -0,0 +1,83 @@
+package importer
+
+import (
+  "encoding/csv"
+  "errors"
+  "io"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// accepted header names for each import column
+var csvColumns = map[string][]string{
+  "name": []string{"name", "dog"},
+  "gender": []string{"gender", "sex"},
+  "slemstatus": []string{"slemstatus", "slem", "shakingdogstatus"},
+  "cecsstatus": []string{"cecsstatus", "cecs"},
+  "sire": []string{"sire", "sirename"},
+  "dam": []string{"dam", "damname"},
+}
+
+
+func ReadCsv(r io.Reader) ([]data.ImportRow, error) {
+  // reads import rows from a CSV file with a header row, in which
+  // only the name column is required
+  reader := csv.NewReader(r)
+  reader.FieldsPerRecord = -1
+  reader.TrimLeadingSpace = true
+
+  // map header names to column indexes
+  header, err := reader.Read()
+  if err == io.EOF {
+    return nil, errors.New("missing header row")
+  } else if err != nil {
+    return nil, err
+  }
+  indexes := map[string]int{}
+  for i, name := range header {
+    name = strings.ToLower(strings.TrimSpace(name))
+    for column, aliases := range csvColumns {
+      if data.StringInSlice(aliases, name) {
+        indexes[column] = i
+      }
+    }
+  }
+  if _, ok := indexes["name"]; !ok {
+    return nil, errors.New("missing name column")
+  }
+
+  // read rows
+  rows := []data.ImportRow{}
+  for {
+    record, err := reader.Read()
+    if err == io.EOF {
+      break
+    } else if err != nil {
+      return nil, err
+    }
+    field := func(column string) string {
+      i, ok := indexes[column]
+      if !ok || i >= len(record) {
+        return ""
+      }
+      return strings.TrimSpace(record[i])
+    }
+
+    // blank lines are common at the end of spreadsheet exports
+    if len(strings.TrimSpace(strings.Join(record, ""))) == 0 {
+      continue
+    }
+    line, _ := reader.FieldPos(0)
+    rows = append(rows, data.ImportRow{
+      Line: line,
+      Name: field("name"),
+      Gender: strings.ToUpper(field("gender")),
+      ShakingDogStatus: field("slemstatus"),
+      CecsStatus: field("cecsstatus"),
+      SireName: field("sire"),
+      DamName: field("dam"),
+    })
+  }
+  return rows, nil
+}
@@ -0,0 +1,158 @@
+package importer
+
+import (
+  "bufio"
+  "errors"
+  "fmt"
+  "io"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// genders for each GEDCOM sex code
+// NOTE: unknown maps to blank so that it does not clash with the
+//       gender already held in the register
+var gedcomGenders = map[string]string{
+  "M": "D",
+  "F": "B",
+  "U": "",
+}
+
+type gedcomIndividual struct {
+  line int
+  name string
+  gender string
+  slemStatus string
+  cecsStatus string
+}
+
+type gedcomFamily struct {
+  husband string
+  wife string
+  children []string
+}
+
+
+func ReadGedcom(r io.Reader) ([]data.ImportRow, error) {
+  // reads import rows from GEDCOM individual and family records, with
+  // each individual's parents taken from the family it is a child of
+  individuals := map[string]*gedcomIndividual{}
+  order := []string{}
+  families := []*gedcomFamily{}
+  var individual *gedcomIndividual
+  var family *gedcomFamily
+
+  scanner := bufio.NewScanner(r)
+  line := 0
+  for scanner.Scan() {
+    line++
+    text := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+    if len(text) == 0 {
+      continue
+    }
+
+    // each line is: level [@xref@] tag [value]
+    fields := strings.SplitN(text, " ", 3)
+    if len(fields) < 2 {
+      return nil, fmt.Errorf("line %d: invalid record", line)
+    }
+    level := fields[0]
+    xref := ""
+    if strings.HasPrefix(fields[1], "@") {
+      xref = fields[1]
+      fields = append(fields[:1], strings.SplitN(strings.Join(fields[2:], " "), " ", 2)...)
+    }
+    tag := strings.ToUpper(fields[1])
+    value := ""
+    if len(fields) > 2 {
+      value = strings.TrimSpace(fields[2])
+    }
+
+    // a new top level record ends the previous one
+    if level == "0" {
+      individual = nil
+      family = nil
+      switch tag {
+      case "INDI":
+        if len(xref) == 0 {
+          return nil, fmt.Errorf("line %d: individual without a reference", line)
+        }
+        individual = &gedcomIndividual{line: line}
+        individuals[xref] = individual
+        order = append(order, xref)
+      case "FAM":
+        family = &gedcomFamily{}
+        families = append(families, family)
+      }
+      continue
+    }
+    if level != "1" {
+      continue
+    }
+    if individual != nil {
+      switch tag {
+      case "NAME":
+        individual.name = gedcomName(value)
+      case "SEX":
+        gender, ok := gedcomGenders[strings.ToUpper(value)]
+        if !ok {
+          gender = value
+        }
+        individual.gender = gender
+      case "_SLEM":
+        individual.slemStatus = value
+      case "_CECS":
+        individual.cecsStatus = value
+      }
+    } else if family != nil {
+      switch tag {
+      case "HUSB":
+        family.husband = value
+      case "WIFE":
+        family.wife = value
+      case "CHIL":
+        family.children = append(family.children, value)
+      }
+    }
+  }
+  if err := scanner.Err(); err != nil {
+    return nil, err
+  }
+  if len(order) == 0 {
+    return nil, errors.New("no individuals found")
+  }
+
+  // parents are only known when both are in the file
+  parents := map[string][2]string{}
+  for _, family := range families {
+    husband, ok1 := individuals[family.husband]
+    wife, ok2 := individuals[family.wife]
+    if !ok1 || !ok2 {
+      continue
+    }
+    for _, child := range family.children {
+      parents[child] = [2]string{husband.name, wife.name}
+    }
+  }
+  rows := []data.ImportRow{}
+  for _, xref := range order {
+    individual := individuals[xref]
+    rows = append(rows, data.ImportRow{
+      Line: individual.line,
+      Name: individual.name,
+      Gender: individual.gender,
+      ShakingDogStatus: individual.slemStatus,
+      CecsStatus: individual.cecsStatus,
+      SireName: parents[xref][0],
+      DamName: parents[xref][1],
+    })
+  }
+  return rows, nil
+}
+
+func gedcomName(value string) string {
+  // names may have the surname marked with slashes, e.g. "Rex /Smith/"
+  value = strings.Replace(value, "@@", "@", -1)
+  return strings.Join(strings.Fields(strings.Replace(value, "/", " ", -1)), " ")
+}
@@ -0,0 +1,247 @@
+package importer
+
+import (
+  "crypto/sha256"
+  "database/sql"
+  "fmt"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+const (
+  OutcomeCreated = "created"
+  OutcomeUpdated = "updated"
+  OutcomeSkipped = "skipped"
+  OutcomeRejected = "rejected"
+
+  rowSavepoint = "importrow"
+)
+
+
+func Import(txConn *db.Connection, rows []data.ImportRow, actor string, dryRun bool) (*data.ImportReport, error) {
+  // applies each row to the register within the caller's transaction
+  // NOTE: a rejected row is rolled back on its own so the remaining rows
+  //       can still be applied, whereas a DB error stops the import and
+  //       the caller is expected to roll back the whole transaction
+  report := &data.ImportReport{
+    DryRun: dryRun,
+    Rows: []data.ImportRowResult{},
+  }
+  for i, _ := range rows {
+    row := &rows[i]
+    result := data.ImportRowResult{
+      Line: row.Line,
+      Name: row.Name,
+      Messages: []string{},
+    }
+
+    err := txConn.Savepoint(rowSavepoint)
+    if err != nil {
+      return nil, err
+    }
+    rejected, err := importRow(txConn, row, actor, &result)
+    if err != nil {
+      return nil, fmt.Errorf("line %d: %v", row.Line, err)
+    }
+    if rejected {
+      err = txConn.RollbackTo(rowSavepoint)
+      if err != nil {
+        return nil, err
+      }
+      result.Outcome = OutcomeRejected
+      result.ParentsCreated = 0
+    }
+
+    // tally up
+    switch result.Outcome {
+    case OutcomeCreated:
+      report.Created++
+    case OutcomeUpdated:
+      report.Updated++
+    case OutcomeSkipped:
+      report.Skipped++
+    case OutcomeRejected:
+      report.Rejected++
+    }
+    report.ParentsCreated += result.ParentsCreated
+    report.Rows = append(report.Rows, result)
+  }
+  return report, nil
+}
+
+func SaveAuditSummary(txConn *db.Connection, source string, content []byte, summary, actor string) error {
+  // records the import as a whole, so that repeated imports of the
+  // same (or an updated) file can be told apart in the audit log
+  return db.SaveAuditEntry(txConn, &data.AuditEntry{
+    Actor: actor,
+    Action: fmt.Sprintf("Imported %s; SHA-256 = '%x'; %s", source, sha256.Sum256(content), summary),
+    EntityType: "import",
+    Operation: "create",
+  })
+}
+
+func Summary(report *data.ImportReport) string {
+  return fmt.Sprintf("Created = %d; Parents created = %d; Updated = %d; Skipped = %d; Rejected = %d",
+    report.Created,
+    report.ParentsCreated,
+    report.Updated,
+    report.Skipped,
+    report.Rejected,
+  )
+}
+
+func importRow(txConn *db.Connection, row *data.ImportRow, actor string, result *data.ImportRowResult) (bool, error) {
+  // applies a single row, returning true if the row was rejected
+  reject := func(format string, a ...interface{}) (bool, error) {
+    result.Messages = append(result.Messages, fmt.Sprintf(format, a...))
+    return true, nil
+  }
+  if len(row.Name) == 0 {
+    return reject("Missing name")
+  }
+
+  // FIRST, find or create the dog
+  dog, err := db.GetDogByName(txConn, row.Name)
+  if err == sql.ErrNoRows {
+    dog = data.Dog{
+      Name: row.Name,
+      Gender: withDefault(row.Gender, "U"),
+      ShakingDogStatus: withDefault(row.ShakingDogStatus, "Unknown"),
+      CecsStatus: withDefault(row.CecsStatus, "Unknown"),
+    }
+    if !data.IsValidDog(&dog) {
+      return reject("Invalid gender or status")
+    }
+    err = db.SaveNewDog(txConn, &dog, actor)
+    if err != nil {
+      return false, err
+    }
+    result.Outcome = OutcomeCreated
+  } else if err != nil {
+    return false, err
+  } else {
+    // existing dogs can have their statuses updated, but a gender
+    // change is too risky to make in bulk
+    if len(row.Gender) > 0 && row.Gender != dog.Gender {
+      return reject("Gender '%s' does not match register ('%s')", row.Gender, dog.Gender)
+    }
+    update := data.TestResultDog{
+      Id: dog.Id,
+      Name: dog.Name,
+      Gender: dog.Gender,
+      ShakingDogStatus: withDefault(row.ShakingDogStatus, dog.ShakingDogStatus),
+      CecsStatus: withDefault(row.CecsStatus, dog.CecsStatus),
+      OrigShakingDogStatus: dog.ShakingDogStatus,
+      OrigCecsDogStatus: dog.CecsStatus,
+    }
+    if !data.IsValidDog(update.AsDataDog()) {
+      return reject("Invalid status")
+    }
+    if update.ShakingDogStatus != dog.ShakingDogStatus || update.CecsStatus != dog.CecsStatus {
+      err = db.UpdateStatusesAndFlags(txConn, &update, actor)
+      if err != nil {
+        return false, err
+      }
+      result.Outcome = OutcomeUpdated
+      if update.ShakingDogStatus != dog.ShakingDogStatus {
+        result.Messages = append(result.Messages,
+          fmt.Sprintf("SLEM status '%s' => '%s'", dog.ShakingDogStatus, update.ShakingDogStatus))
+      }
+      if update.CecsStatus != dog.CecsStatus {
+        result.Messages = append(result.Messages,
+          fmt.Sprintf("CECS status '%s' => '%s'", dog.CecsStatus, update.CecsStatus))
+      }
+    }
+  }
+
+  // THEN, set parents (if supplied) with following rules:
+  //   1) if dog has no parents, both Sire and Dam are required
+  //   2) missing parents are created with Unknown statuses
+  if len(row.SireName) == 0 && len(row.DamName) == 0 {
+    if result.Outcome == "" {
+      result.Outcome = OutcomeSkipped
+    }
+    return false, nil
+  }
+  sire, dam, err := db.GetParents(txConn, dog.Id)
+  if err != nil && err != sql.ErrNoRows {
+    return false, err
+  }
+  hasParents := err == nil
+
+  // check rule #1
+  if !hasParents && (len(row.SireName) == 0 || len(row.DamName) == 0) {
+    return reject("Both Sire and Dam are needed")
+  }
+
+  // check rule #2
+  newSire, newDam := sire, dam
+  if len(row.SireName) > 0 {
+    newSire, err = findOrCreateParent(txConn, row.SireName, "D", actor, result)
+    if err != nil {
+      return false, err
+    }
+  }
+  if len(row.DamName) > 0 {
+    newDam, err = findOrCreateParent(txConn, row.DamName, "B", actor, result)
+    if err != nil {
+      return false, err
+    }
+  }
+  if newSire.Gender != "D" {
+    return reject("Sire '%s' is not a dog", newSire.Name)
+  }
+  if newDam.Gender != "B" {
+    return reject("Dam '%s' is not a bitch", newDam.Name)
+  }
+  if newSire.Id == dog.Id || newDam.Id == dog.Id {
+    return reject("Dog cannot be its own parent")
+  }
+
+  // only touch the relationship if it has changed
+  if !hasParents || newSire.Id != sire.Id || newDam.Id != dam.Id {
+    err = db.SaveRelationship(txConn, newSire.Id, newDam.Id, dog.Id, actor)
+    if err != nil {
+      return false, err
+    }
+    if result.Outcome == "" {
+      result.Outcome = OutcomeUpdated
+    }
+    result.Messages = append(result.Messages,
+      fmt.Sprintf("Parents set to Sire '%s' and Dam '%s'", newSire.Name, newDam.Name))
+  }
+  if result.Outcome == "" {
+    result.Outcome = OutcomeSkipped
+  }
+  return false, nil
+}
+
+func findOrCreateParent(txConn *db.Connection, name, gender, actor string, result *data.ImportRowResult) (data.Dog, error) {
+  // fetches a parent by name, or creates it if it's not in the register
+  parent, err := db.GetDogByName(txConn, name)
+  if err != sql.ErrNoRows {
+    return parent, err
+  }
+  parent = data.Dog{
+    Name: name,
+    Gender: gender,
+    ShakingDogStatus: "Unknown",
+    CecsStatus: "Unknown",
+  }
+  err = db.SaveNewDog(txConn, &parent, actor)
+  if err != nil {
+    return parent, err
+  }
+  result.ParentsCreated++
+  result.Messages = append(result.Messages, fmt.Sprintf("Created parent '%s'", name))
+  return parent, nil
+}
+
+func withDefault(value, defaultValue string) string {
+  if len(strings.TrimSpace(value)) == 0 {
+    return defaultValue
+  }
+  return value
+}
@@ -0,0 +1,271 @@
+package importer
+
+import (
+  "database/sql"
+  "encoding/csv"
+  "errors"
+  "fmt"
+  "io"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/config"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+const (
+  OutcomeApplied = "applied"
+  OutcomeUnchanged = "unchanged"
+  OutcomeUnmatched = "unmatched"
+  OutcomeAmbiguous = "ambiguous"
+)
+
+// only results from a lab are accepted, never inferred statuses
+var labStatuses = []string{"Affected", "Carrier", "Clear"}
+
+// apostrophe styles that vary between labs and the register
+var apostrophes = []string{"'", "’", "‘", "`"}
+
+
+func ReadLabCsv(r io.Reader, lab *config.Lab) ([]data.LabResult, error) {
+  // reads results from a lab's CSV report using the lab's column layout
+  reader := csv.NewReader(r)
+  reader.FieldsPerRecord = -1
+  reader.TrimLeadingSpace = true
+
+  // map header names to column indexes
+  header, err := reader.Read()
+  if err == io.EOF {
+    return nil, errors.New("missing header row")
+  } else if err != nil {
+    return nil, err
+  }
+  indexes := map[string]int{}
+  columns := map[string]string{
+    "sampleid": lab.SampleIDColumn,
+    "dog": lab.DogColumn,
+    "registration": lab.RegistrationColumn,
+    "ailment": lab.AilmentColumn,
+    "result": lab.ResultColumn,
+  }
+  for i, name := range header {
+    for column, labName := range columns {
+      if len(labName) > 0 && strings.EqualFold(strings.TrimSpace(name), labName) {
+        indexes[column] = i
+      }
+    }
+  }
+  for _, column := range []string{"ailment", "result"} {
+    if _, ok := indexes[column]; !ok {
+      return nil, fmt.Errorf("missing '%s' column", columns[column])
+    }
+  }
+  _, hasDog := indexes["dog"]
+  _, hasRegistration := indexes["registration"]
+  if !hasDog && !hasRegistration {
+    return nil, fmt.Errorf("missing '%s' column", lab.DogColumn)
+  }
+
+  // read rows
+  results := []data.LabResult{}
+  for {
+    record, err := reader.Read()
+    if err == io.EOF {
+      break
+    } else if err != nil {
+      return nil, err
+    }
+    field := func(column string) string {
+      i, ok := indexes[column]
+      if !ok || i >= len(record) {
+        return ""
+      }
+      return strings.TrimSpace(record[i])
+    }
+    if len(strings.TrimSpace(strings.Join(record, ""))) == 0 {
+      continue
+    }
+    line, _ := reader.FieldPos(0)
+    results = append(results, data.LabResult{
+      Line: line,
+      SampleId: field("sampleid"),
+      DogName: field("dog"),
+      Registration: field("registration"),
+      Ailment: field("ailment"),
+      Result: field("result"),
+    })
+  }
+  return results, nil
+}
+
+func ImportLabResults(txConn *db.Connection, lab *config.Lab, results []data.LabResult, actor string, dryRun bool) (*data.LabImportReport, error) {
+  // matches each result to a dog and updates its status the same way
+  // as an individually entered test result
+  // NOTE: only unambiguous matches are applied, everything else is
+  //       reported back for manual resolution
+  report := &data.LabImportReport{
+    DryRun: dryRun,
+    Rows: []data.LabResultOutcome{},
+  }
+  for _, result := range results {
+    outcome := data.LabResultOutcome{
+      Line: result.Line,
+      SampleId: result.SampleId,
+      DogName: result.DogName,
+      Registration: result.Registration,
+      Ailment: mapLabValue(lab.Ailments, result.Ailment),
+      Status: mapLabValue(lab.Results, result.Result),
+      Candidates: []data.Dog{},
+    }
+    err := applyLabResult(txConn, &outcome, actor)
+    if err != nil {
+      return nil, fmt.Errorf("line %d: %v", result.Line, err)
+    }
+
+    // tally up
+    switch outcome.Outcome {
+    case OutcomeApplied:
+      report.Applied++
+    case OutcomeUnchanged:
+      report.Unchanged++
+    case OutcomeUnmatched:
+      report.Unmatched++
+    case OutcomeAmbiguous:
+      report.Ambiguous++
+    case OutcomeRejected:
+      report.Rejected++
+    }
+    report.Rows = append(report.Rows, outcome)
+  }
+  return report, nil
+}
+
+func LabSummary(report *data.LabImportReport) string {
+  return fmt.Sprintf("Applied = %d; Unchanged = %d; Unmatched = %d; Ambiguous = %d; Rejected = %d",
+    report.Applied,
+    report.Unchanged,
+    report.Unmatched,
+    report.Ambiguous,
+    report.Rejected,
+  )
+}
+
+func applyLabResult(txConn *db.Connection, outcome *data.LabResultOutcome, actor string) error {
+  // validate the result itself
+  if outcome.Ailment != "SLEM" && outcome.Ailment != "CECS" {
+    outcome.Outcome = OutcomeRejected
+    outcome.Message = fmt.Sprintf("Unknown test '%s'", outcome.Ailment)
+    return nil
+  }
+  if !data.StringInSlice(labStatuses, outcome.Status) {
+    outcome.Outcome = OutcomeRejected
+    outcome.Message = fmt.Sprintf("Unknown result '%s'", outcome.Status)
+    return nil
+  }
+
+  // the register only holds registered names, so a lab that leaves
+  // out the name is matched on what it gives as the registration
+  name := outcome.DogName
+  if len(name) == 0 {
+    name = outcome.Registration
+  }
+  if len(name) == 0 {
+    outcome.Outcome = OutcomeUnmatched
+    outcome.Message = "Missing dog name"
+    return nil
+  }
+  dog, candidates, err := MatchDog(txConn, name)
+  if err != nil {
+    return err
+  }
+  if dog == nil {
+    outcome.Candidates = candidates
+    outcome.Outcome = OutcomeUnmatched
+    outcome.Message = fmt.Sprintf("No dog named '%s'", name)
+    if len(candidates) > 0 {
+      outcome.Outcome = OutcomeAmbiguous
+      outcome.Message = fmt.Sprintf("%d possible dogs named like '%s'", len(candidates), name)
+    }
+    return nil
+  }
+  outcome.Dog = dog
+
+  // same update (and override flag handling) as a manual test result
+  update := data.TestResultDog{
+    Id: dog.Id,
+    Name: dog.Name,
+    Gender: dog.Gender,
+    ShakingDogStatus: dog.ShakingDogStatus,
+    CecsStatus: dog.CecsStatus,
+    OrigShakingDogStatus: dog.ShakingDogStatus,
+    OrigCecsDogStatus: dog.CecsStatus,
+  }
+  previous := dog.ShakingDogStatus
+  if outcome.Ailment == "SLEM" {
+    update.ShakingDogStatus = outcome.Status
+  } else {
+    previous = dog.CecsStatus
+    update.CecsStatus = outcome.Status
+  }
+  if previous == outcome.Status {
+    outcome.Outcome = OutcomeUnchanged
+    return nil
+  }
+  err = db.UpdateStatusesAndFlags(txConn, &update, actor)
+  if err != nil {
+    return err
+  }
+  outcome.Outcome = OutcomeApplied
+  outcome.Message = fmt.Sprintf("%s status '%s' => '%s'", outcome.Ailment, previous, outcome.Status)
+  return nil
+}
+
+func MatchDog(dbConn *db.Connection, name string) (*data.Dog, []data.Dog, error) {
+  // finds the dog a name refers to, allowing for differences in case
+  // and apostrophe style, or else any dogs it could refer to
+  dog, err := db.GetDogByName(dbConn, name)
+  if err == nil {
+    return &dog, nil, nil
+  } else if err != sql.ErrNoRows {
+    return nil, nil, err
+  }
+
+  // loose search, with each apostrophe matching any character
+  pattern := db.EscapeLike(name)
+  for _, apostrophe := range apostrophes {
+    pattern = strings.Replace(pattern, apostrophe, "_", -1)
+  }
+  candidates, err := db.FindDogsByName(dbConn, "%" + pattern + "%")
+  if err != nil {
+    return nil, nil, err
+  }
+  same := []data.Dog{}
+  for _, candidate := range candidates {
+    if normaliseName(candidate.Name) == normaliseName(name) {
+      same = append(same, candidate)
+    }
+  }
+  if len(same) == 1 {
+    return &same[0], nil, nil
+  }
+  return nil, candidates, nil
+}
+
+func normaliseName(name string) string {
+  name = strings.ToLower(strings.TrimSpace(name))
+  for _, apostrophe := range apostrophes {
+    name = strings.Replace(name, apostrophe, "'", -1)
+  }
+  return name
+}
+
+func mapLabValue(values map[string]string, value string) string {
+  // translates a lab's value using the lab's mapping, ignoring case,
+  // and passes through values that need no translation
+  for labValue, registerValue := range values {
+    if strings.EqualFold(labValue, value) {
+      return registerValue
+    }
+  }
+  return value
+}
@@ -0,0 +1,305 @@
+package importer
+
+import (
+  "archive/zip"
+  "encoding/xml"
+  "errors"
+  "io"
+  "path"
+  "strconv"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+var errXlsxPartMissing = errors.New("workbook part missing")
+
+// the last column a sheet can have, XFD
+const xlsxMaxColumns = 16384
+
+// statuses as abbreviated in the register spreadsheet
+// NOTE: Unknown maps to blank so that an "Unk" parent does not
+//       wipe out a status already held in the register
+var xlsxStatuses = map[string]string{
+  "affected": "Affected",
+  "carrier": "Carrier",
+  "carrierbyprogeny": "CarrierByProgeny",
+  "pbyp": "CarrierByProgeny",
+  "clear": "Clear",
+  "clearbyparentage": "ClearByParentage",
+  "cbyp": "ClearByParentage",
+  "unknown": "",
+  "unk": "",
+}
+
+type xlsxWorkbook struct {
+  Sheets []struct {
+    Name string `xml:"name,attr"`
+    RelId string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+  } `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+  Relationships []struct {
+    Id string `xml:"Id,attr"`
+    Target string `xml:"Target,attr"`
+  } `xml:"Relationship"`
+}
+
+type xlsxSharedStrings struct {
+  Items []xlsxText `xml:"si"`
+}
+
+// text is either held directly or split into formatted runs
+type xlsxText struct {
+  Text string `xml:"t"`
+  Runs []struct {
+    Text string `xml:"t"`
+  } `xml:"r"`
+}
+
+type xlsxSheet struct {
+  Rows []struct {
+    Number int `xml:"r,attr"`
+    Cells []struct {
+      Ref string `xml:"r,attr"`
+      Type string `xml:"t,attr"`
+      Value string `xml:"v"`
+      Inline xlsxText `xml:"is"`
+    } `xml:"c"`
+  } `xml:"sheetData>row"`
+}
+
+
+func ReadXlsx(r io.ReaderAt, size int64) ([]data.ImportRow, error) {
+  // reads import rows from the first sheet of a workbook in the layout
+  // the register was originally kept in, i.e. columns of:
+  //   Tested Dog | Sex | Status | Sire | Status | Dam | Status
+  // where each Status column belongs to the dog named before it
+  archive, err := zip.NewReader(r, size)
+  if err != nil {
+    return nil, err
+  }
+  cells, err := readFirstSheet(archive)
+  if err != nil {
+    return nil, err
+  }
+  if len(cells) == 0 {
+    return nil, errors.New("missing header row")
+  }
+
+  // map header names to column indexes
+  indexes := map[string]int{}
+  last := ""
+  for i, name := range cells[0].values {
+    switch strings.ToLower(strings.TrimSpace(name)) {
+    case "tested dog", "dog", "name":
+      last = "name"
+    case "sex", "gender":
+      indexes["gender"] = i
+      continue
+    case "sire":
+      last = "sire"
+    case "dam":
+      last = "dam"
+    case "status":
+      if last != "" {
+        indexes[last + "status"] = i
+      }
+      continue
+    default:
+      continue
+    }
+    indexes[last] = i
+  }
+  if _, ok := indexes["name"]; !ok {
+    return nil, errors.New("missing Tested Dog column")
+  }
+
+  // each sheet row describes a dog and its parents, so the parents'
+  // statuses are imported first as rows of their own
+  rows := []data.ImportRow{}
+  for _, row := range cells[1:] {
+    field := func(column string) string {
+      i, ok := indexes[column]
+      if !ok || i >= len(row.values) {
+        return ""
+      }
+      return strings.TrimSpace(row.values[i])
+    }
+    name := field("name")
+    if len(name) == 0 {
+      continue
+    }
+    if sire := field("sire"); len(sire) > 0 {
+      rows = append(rows, data.ImportRow{
+        Line: row.number,
+        Name: sire,
+        Gender: "D",
+        ShakingDogStatus: xlsxStatus(field("sirestatus")),
+      })
+    }
+    if dam := field("dam"); len(dam) > 0 {
+      rows = append(rows, data.ImportRow{
+        Line: row.number,
+        Name: dam,
+        Gender: "B",
+        ShakingDogStatus: xlsxStatus(field("damstatus")),
+      })
+    }
+    rows = append(rows, data.ImportRow{
+      Line: row.number,
+      Name: name,
+      Gender: strings.ToUpper(field("gender")),
+      ShakingDogStatus: xlsxStatus(field("namestatus")),
+      SireName: field("sire"),
+      DamName: field("dam"),
+    })
+  }
+  return rows, nil
+}
+
+func xlsxStatus(value string) string {
+  // expands abbreviated statuses, passing through anything unrecognised
+  // so it's reported as invalid by the import
+  status, ok := xlsxStatuses[strings.ToLower(value)]
+  if !ok {
+    return value
+  }
+  return status
+}
+
+type xlsxRow struct {
+  number int
+  values []string
+}
+
+func readFirstSheet(archive *zip.Reader) ([]xlsxRow, error) {
+  // reads the cell values of the first sheet in a workbook
+  var workbook xlsxWorkbook
+  err := readXml(archive, "xl/workbook.xml", &workbook)
+  if err != nil {
+    return nil, err
+  }
+  if len(workbook.Sheets) == 0 {
+    return nil, errors.New("workbook has no sheets")
+  }
+  var rels xlsxRelationships
+  err = readXml(archive, "xl/_rels/workbook.xml.rels", &rels)
+  if err != nil {
+    return nil, err
+  }
+  sheetPath := ""
+  for _, rel := range rels.Relationships {
+    if rel.Id == workbook.Sheets[0].RelId {
+      sheetPath = path.Join("xl", rel.Target)
+      if strings.HasPrefix(rel.Target, "/") {
+        sheetPath = strings.TrimPrefix(rel.Target, "/")
+      }
+    }
+  }
+  if sheetPath == "" {
+    return nil, errors.New("first sheet not found")
+  }
+
+  // shared strings are optional, a workbook of numbers has none
+  var sharedStrings xlsxSharedStrings
+  err = readXml(archive, "xl/sharedStrings.xml", &sharedStrings)
+  if err != nil && err != errXlsxPartMissing {
+    return nil, err
+  }
+  var sheet xlsxSheet
+  err = readXml(archive, sheetPath, &sheet)
+  if err != nil {
+    return nil, err
+  }
+
+  // row and cell references are optional, in which case each follows
+  // on from the one before
+  rows := []xlsxRow{}
+  number := 0
+  for _, sheetRow := range sheet.Rows {
+    number++
+    if sheetRow.Number > 0 {
+      number = sheetRow.Number
+    }
+    row := xlsxRow{number: number, values: []string{}}
+    for _, cell := range sheetRow.Cells {
+      column := len(row.values)
+      if len(cell.Ref) > 0 {
+        column, err = xlsxColumn(cell.Ref)
+        if err != nil {
+          return nil, err
+        }
+      }
+      for len(row.values) <= column {
+        row.values = append(row.values, "")
+      }
+      switch cell.Type {
+      case "s":
+        i, err := strconv.Atoi(cell.Value)
+        if err != nil || i < 0 || i >= len(sharedStrings.Items) {
+          return nil, errors.New("bad shared string in cell " + cell.Ref)
+        }
+        row.values[column] = sharedStrings.Items[i].String()
+      case "inlineStr":
+        row.values[column] = cell.Inline.String()
+      default:
+        row.values[column] = cell.Value
+      }
+    }
+    rows = append(rows, row)
+  }
+  return rows, nil
+}
+
+func (t xlsxText) String() string {
+  if len(t.Runs) == 0 {
+    return t.Text
+  }
+  s := ""
+  for _, run := range t.Runs {
+    s += run.Text
+  }
+  return s
+}
+
+func xlsxColumn(ref string) (int, error) {
+  // converts the letters of a cell reference to a zero-based column,
+  // where a reference is letters then digits, e.g. "AB12"
+  column := 0
+  letters := 0
+  for _, c := range strings.ToUpper(ref) {
+    if c < 'A' || c > 'Z' {
+      break
+    }
+    column = column * 26 + int(c - 'A' + 1)
+    letters++
+    if column > xlsxMaxColumns {
+      return 0, errors.New("bad cell reference " + ref)
+    }
+  }
+  if letters == 0 || letters == len(ref) {
+    return 0, errors.New("bad cell reference " + ref)
+  }
+  if _, err := strconv.Atoi(ref[letters:]); err != nil {
+    return 0, errors.New("bad cell reference " + ref)
+  }
+  return column - 1, nil
+}
+
+func readXml(archive *zip.Reader, name string, v interface{}) error {
+  // decodes an XML part of the workbook archive
+  for _, f := range archive.File {
+    if f.Name != name {
+      continue
+    }
+    rc, err := f.Open()
+    if err != nil {
+      return err
+    }
+    defer rc.Close()
+    return xml.NewDecoder(rc).Decode(v)
+  }
+  return errXlsxPartMissing
+}
@@ -0,0 +1,41 @@
+USE shakingdog;
+ALTER TABLE audit
+    ADD COLUMN entitytype varchar(20) NULL,
+    ADD COLUMN entityid bigint unsigned NULL,
+    ADD COLUMN operation varchar(20) NULL,
+    ADD COLUMN diff text NULL;
+
+-- fill in the entity of existing entries from their action text, where
+-- the dog named is matched by its current name, so entries for dogs
+-- renamed since are left without one
+-- NOTE: there's no diff to recover, so these can't be reverted
+UPDATE audit a
+JOIN dog d
+  ON d.name = SUBSTRING_INDEX(SUBSTRING_INDEX(a.action, 'Name = ''', -1), '''; ', 1)
+SET a.entitytype = 'dog', a.entityid = d.id, a.operation = 'create'
+WHERE a.entitytype IS NULL AND a.action LIKE 'Saved new dog; %';
+
+UPDATE audit a
+JOIN dog d
+  ON d.name = SUBSTRING_INDEX(SUBSTRING_INDEX(a.action, 'Name = ''', -1), '''; ', 1)
+SET a.entitytype = 'dog', a.entityid = d.id, a.operation = 'update'
+WHERE a.entitytype IS NULL AND a.action LIKE 'Updated SLEM status; %';
+
+UPDATE audit a
+JOIN dog d
+  ON d.name = SUBSTRING_INDEX(SUBSTRING_INDEX(a.action, '''; Gender ''', 1), ''' => ''', -1)
+SET a.entitytype = 'dog', a.entityid = d.id, a.operation = 'update'
+WHERE a.entitytype IS NULL AND a.action LIKE 'Updated details; %';
+
+UPDATE audit a
+JOIN dog d
+  ON d.name = SUBSTRING(SUBSTRING_INDEX(a.action, 'Child = ''', -1), 1,
+    CHAR_LENGTH(SUBSTRING_INDEX(a.action, 'Child = ''', -1)) - 1)
+SET a.entitytype = 'relationship', a.entityid = d.id, a.operation = 'create'
+WHERE a.entitytype IS NULL AND a.action LIKE 'Saved new relationship; %';
+
+UPDATE audit a
+JOIN dog d
+  ON d.name = SUBSTRING_INDEX(SUBSTRING_INDEX(a.action, 'Child = ''', -1), '''; ', 1)
+SET a.entitytype = 'relationship', a.entityid = d.id, a.operation = 'update'
+WHERE a.entitytype IS NULL AND a.action LIKE 'Updated parent (%) of child; %';
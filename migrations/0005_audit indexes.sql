@@ -0,0 +1,4 @@
+USE shakingdog;
+ALTER TABLE audit
+    ADD INDEX (stamp),
+    ADD INDEX (entitytype, entityid);
@@ -0,0 +1,3 @@
+USE shakingdog;
+ALTER TABLE audit
+    ADD COLUMN revertsid bigint unsigned NULL;
@@ -0,0 +1,68 @@
+USE shakingdog;
+CREATE TABLE doghistory (
+    id bigint unsigned NOT NULL auto_increment PRIMARY KEY,
+    dogid bigint unsigned NOT NULL,
+    name varchar(180) NOT NULL,
+    gender varchar(10) NOT NULL,
+    shakingdogstatusid bigint unsigned NOT NULL,
+    cecsstatusid bigint unsigned NOT NULL,
+    shakingdoginferoverride boolean NOT NULL,
+    cecsinferoverride boolean NOT NULL,
+    deleted boolean NOT NULL,
+    validfrom timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX(validfrom, dogid));
+CREATE TABLE relationshiphistory (
+    id bigint unsigned NOT NULL auto_increment PRIMARY KEY,
+    childid bigint unsigned NOT NULL,
+    sireid bigint unsigned NOT NULL,
+    damid bigint unsigned NOT NULL,
+    deleted boolean NOT NULL,
+    validfrom timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX(validfrom, childid));
+
+-- every write to dog and relationship is copied by trigger, so that
+-- changes made by the inferupdate job and stored procs are included
+DELIMITER $$
+CREATE DEFINER=`root`@`%` TRIGGER `doghistory_insert` AFTER INSERT ON dog FOR EACH ROW
+BEGIN
+INSERT INTO doghistory (`dogid`, `name`, `gender`, `shakingdogstatusid`, `cecsstatusid`, `shakingdoginferoverride`, `cecsinferoverride`, `deleted`)
+VALUES (NEW.id, NEW.name, NEW.gender, NEW.shakingdogstatusid, NEW.cecsstatusid, NEW.shakingdoginferoverride, NEW.cecsinferoverride, 0);
+END$$
+CREATE DEFINER=`root`@`%` TRIGGER `doghistory_update` AFTER UPDATE ON dog FOR EACH ROW
+BEGIN
+INSERT INTO doghistory (`dogid`, `name`, `gender`, `shakingdogstatusid`, `cecsstatusid`, `shakingdoginferoverride`, `cecsinferoverride`, `deleted`)
+VALUES (NEW.id, NEW.name, NEW.gender, NEW.shakingdogstatusid, NEW.cecsstatusid, NEW.shakingdoginferoverride, NEW.cecsinferoverride, 0);
+END$$
+CREATE DEFINER=`root`@`%` TRIGGER `doghistory_delete` AFTER DELETE ON dog FOR EACH ROW
+BEGIN
+INSERT INTO doghistory (`dogid`, `name`, `gender`, `shakingdogstatusid`, `cecsstatusid`, `shakingdoginferoverride`, `cecsinferoverride`, `deleted`)
+VALUES (OLD.id, OLD.name, OLD.gender, OLD.shakingdogstatusid, OLD.cecsstatusid, OLD.shakingdoginferoverride, OLD.cecsinferoverride, 1);
+END$$
+CREATE DEFINER=`root`@`%` TRIGGER `relationshiphistory_insert` AFTER INSERT ON relationship FOR EACH ROW
+BEGIN
+INSERT INTO relationshiphistory (`childid`, `sireid`, `damid`, `deleted`)
+VALUES (NEW.childid, NEW.sireid, NEW.damid, 0);
+END$$
+CREATE DEFINER=`root`@`%` TRIGGER `relationshiphistory_update` AFTER UPDATE ON relationship FOR EACH ROW
+BEGIN
+IF OLD.childid <> NEW.childid THEN
+  INSERT INTO relationshiphistory (`childid`, `sireid`, `damid`, `deleted`)
+  VALUES (OLD.childid, OLD.sireid, OLD.damid, 1);
+END IF;
+INSERT INTO relationshiphistory (`childid`, `sireid`, `damid`, `deleted`)
+VALUES (NEW.childid, NEW.sireid, NEW.damid, 0);
+END$$
+CREATE DEFINER=`root`@`%` TRIGGER `relationshiphistory_delete` AFTER DELETE ON relationship FOR EACH ROW
+BEGIN
+INSERT INTO relationshiphistory (`childid`, `sireid`, `damid`, `deleted`)
+VALUES (OLD.childid, OLD.sireid, OLD.damid, 1);
+END$$
+DELIMITER ;
+
+-- the register before this migration is only known as it stands now
+INSERT INTO doghistory (`dogid`, `name`, `gender`, `shakingdogstatusid`, `cecsstatusid`, `shakingdoginferoverride`, `cecsinferoverride`, `deleted`)
+SELECT `id`, `name`, `gender`, `shakingdogstatusid`, `cecsstatusid`, `shakingdoginferoverride`, `cecsinferoverride`, 0
+FROM dog;
+INSERT INTO relationshiphistory (`childid`, `sireid`, `damid`, `deleted`)
+SELECT `childid`, `sireid`, `damid`, 0
+FROM relationship;
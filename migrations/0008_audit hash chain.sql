@@ -0,0 +1,17 @@
+USE shakingdog;
+ALTER TABLE audit
+    ADD COLUMN prevhash char(64) NULL,
+    ADD COLUMN hash char(64) NULL;
+
+-- single row holding the end of the chain, which is locked by each new
+-- audit entry so that entries are chained one at a time
+CREATE TABLE audithead (
+    id tinyint unsigned NOT NULL PRIMARY KEY,
+    lastid bigint unsigned NOT NULL,
+    lasthash char(64) NOT NULL);
+
+-- the chain starts empty, as the hashes are keyed with a secret that
+-- is kept out of the database, so existing entries stay unchained and
+-- the first entry saved after this migration starts the chain
+INSERT INTO audithead (`id`, `lastid`, `lasthash`)
+VALUES (1, 0, '');
@@ -0,0 +1,29 @@
+USE shakingdog;
+-- register changes worth notifying on, written alongside the audit
+-- entry so that only committed changes are ever notified
+CREATE TABLE event (
+    id bigint unsigned NOT NULL auto_increment PRIMARY KEY,
+    stamp timestamp DEFAULT CURRENT_TIMESTAMP,
+    type varchar(40) NOT NULL,
+    auditid bigint unsigned NOT NULL,
+    INDEX(type));
+CREATE TABLE webhook (
+    id bigint unsigned NOT NULL auto_increment PRIMARY KEY,
+    stamp timestamp DEFAULT CURRENT_TIMESTAMP,
+    url varchar(500) NOT NULL,
+    secret varchar(100) NOT NULL,
+    events varchar(200) NOT NULL,
+    active boolean NOT NULL,
+    createdby varchar(50) NOT NULL);
+CREATE TABLE webhookdelivery (
+    id bigint unsigned NOT NULL auto_increment PRIMARY KEY,
+    webhookid bigint unsigned NOT NULL,
+    eventid bigint unsigned NOT NULL,
+    status varchar(20) NOT NULL,
+    attempts int NOT NULL,
+    nextattempt timestamp NULL,
+    lastattempt timestamp NULL,
+    responsecode int NULL,
+    error varchar(500) NULL,
+    INDEX(status, nextattempt),
+    INDEX(webhookid, id));
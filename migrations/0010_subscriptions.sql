@@ -0,0 +1,21 @@
+USE shakingdog;
+CREATE TABLE subscription (
+    id bigint unsigned NOT NULL auto_increment PRIMARY KEY,
+    stamp timestamp DEFAULT CURRENT_TIMESTAMP,
+    username varchar(50) NOT NULL,
+    email varchar(254) NOT NULL,
+    dogid bigint unsigned NOT NULL,
+    descendants boolean NOT NULL,
+    token char(32) NOT NULL,
+    UNIQUE(token),
+    INDEX(dogid),
+    INDEX(username));
+-- status changes waiting to go out in the next batch of emails
+CREATE TABLE notification (
+    id bigint unsigned NOT NULL auto_increment PRIMARY KEY,
+    subscriptionid bigint unsigned NOT NULL,
+    eventid bigint unsigned NOT NULL,
+    dogid bigint unsigned NOT NULL,
+    sent timestamp NULL,
+    claimeduntil timestamp NULL,
+    INDEX(sent, subscriptionid));
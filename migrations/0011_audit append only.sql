@@ -0,0 +1,17 @@
+USE shakingdog;
+-- the audit table is append only for the web user, so that entries
+-- can't be altered or removed with its credentials
+-- NOTE: a table privilege can't be revoked from a database-wide grant,
+--       so UPDATE and DELETE are granted again table by table
+REVOKE UPDATE,DELETE ON shakingdog.* FROM 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.ailmentstatus TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.dog TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.relationship TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.doghistory TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.relationshiphistory TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE ON shakingdog.audithead TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.event TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.webhook TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.webhookdelivery TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.subscription TO 'shakingdog_webuser'@'localhost';
+GRANT UPDATE,DELETE ON shakingdog.notification TO 'shakingdog_webuser'@'localhost';
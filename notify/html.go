@@ -0,0 +1,47 @@
+package notify
+
+import (
+  "html/template"
+  "io"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+var unsubscribeTemplate = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Unsubscribe - SLEM / CECS Register</title>
+<style>
+  body { font-family: Helvetica, Arial, sans-serif; margin: 2em; color: #212121; }
+  .unsubscribe { max-width: 40em; margin: auto; }
+  button { font-size: 1em; padding: 0.4em 1em; }
+</style>
+</head>
+<body>
+<div class="unsubscribe">
+  <h1>SLEM / CECS Register</h1>
+{{if .Done}}
+  <p>{{.Subscription.Email}} will no longer be emailed about status changes to {{.Subscription.Dog.Name}}{{if .Subscription.Descendants}} and its descendants{{end}}.</p>
+{{else}}
+  <p>Stop emailing {{.Subscription.Email}} about status changes to {{.Subscription.Dog.Name}}{{if .Subscription.Descendants}} and its descendants{{end}}?</p>
+  <form method="post">
+    <button type="submit">Unsubscribe</button>
+  </form>
+{{end}}
+</div>
+</body>
+</html>
+`))
+
+
+func WriteUnsubscribeHtml(w io.Writer, subscription *data.Subscription, done bool) error {
+  // writes the page an unsubscribe link opens, which asks for the
+  // unsubscribe to be confirmed (so that following the link alone, as
+  // mail scanners do, changes nothing), or says that it's been done
+  return unsubscribeTemplate.Execute(w, struct {
+    Subscription *data.Subscription
+    Done bool
+  }{subscription, done})
+}
@@ -0,0 +1,272 @@
+package notify
+
+import (
+  "bytes"
+  "crypto/tls"
+  "errors"
+  "fmt"
+  "log"
+  "net"
+  "net/smtp"
+  "net/url"
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/config"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+const (
+  // used when the config doesn't say
+  DefaultBatchMinutes = 15
+  // how long claimed notifications are left before being claimed again,
+  // no email is started unless it can be sent within the lease
+  LeaseTime = 10 * time.Minute
+  // how long the relay has to take each email, from connecting to done
+  SendTimeout = 30 * time.Second
+)
+
+type Notifier struct {
+  DBConn *db.Connection
+  Smtp *config.Smtp
+  // unsubscribe links are this followed by the subscription's token
+  UnsubscribeUrl string
+  // sends a single email, which is SendMail unless replaced
+  Send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+  // hands every unsent notification to send, then marks those whose ids
+  // it returns as sent, which is done in the database unless replaced
+  Batch func(send func([]data.Notification) []int) error
+}
+
+
+func NewNotifier(dbConn *db.Connection, smtpConfig *config.Smtp, unsubscribeUrl string) *Notifier {
+  n := &Notifier{
+    DBConn: dbConn,
+    Smtp: smtpConfig,
+    UnsubscribeUrl: unsubscribeUrl,
+    Send: SendMail,
+  }
+  n.Batch = n.batch
+  return n
+}
+
+func (n *Notifier) Run(stop <-chan struct{}) {
+  // sends a batch of emails every few minutes until stopped
+  minutes := n.Smtp.BatchMinutes
+  if minutes < 1 {
+    minutes = DefaultBatchMinutes
+  }
+  ticker := time.NewTicker(time.Duration(minutes) * time.Minute)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-stop:
+      return
+    case <-ticker.C:
+    }
+    _, err := n.SendBatch()
+    if err != nil {
+      log.Printf("ERROR: Notifier: SendBatch error - %v", err)
+    }
+  }
+}
+
+func (n *Notifier) SendBatch() (int, error) {
+  // sends one email to each subscriber covering every change since the
+  // last batch, returning how many were sent
+  // NOTE: emails that fail are left to be retried with the next batch
+  sent := 0
+  err := n.Batch(func(notifications []data.Notification) []int {
+    // the lease has to cover the last email as well
+    stop := time.Now().Add(LeaseTime - SendTimeout)
+
+    // group by recipient, keeping the order of the changes
+    emails := []string{}
+    byEmail := map[string][]data.Notification{}
+    for _, notification := range notifications {
+      if _, ok := byEmail[notification.Email]; !ok {
+        emails = append(emails, notification.Email)
+      }
+      byEmail[notification.Email] = append(byEmail[notification.Email], notification)
+    }
+
+    // send
+    sentIds := []int{}
+    for _, email := range emails {
+      if time.Now().After(stop) {
+        log.Printf("WARNING: Notifier: Lease running out, leaving the rest for the next batch")
+        break
+      }
+      err := n.send(email, byEmail[email])
+      if err != nil {
+        log.Printf("ERROR: Notifier: Send to '%s' error - %v", email, err)
+        continue
+      }
+      sent++
+      for _, notification := range byEmail[email] {
+        sentIds = append(sentIds, notification.Id)
+      }
+    }
+    return sentIds
+  })
+  if err != nil {
+    return 0, err
+  }
+  return sent, nil
+}
+
+func (n *Notifier) batch(send func([]data.Notification) []int) error {
+  // the notifications are claimed for the lease, so that they can't be
+  // sent twice, and sent once the claim is committed so that nothing is
+  // left locked while waiting on the relay
+  txConn, err := n.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    return err
+  }
+  defer txConn.Rollback()
+  notifications, err := db.ClaimNotifications(txConn, int(LeaseTime / time.Second))
+  if err != nil {
+    return err
+  }
+  err = txConn.Commit()
+  if err != nil {
+    return err
+  }
+
+  // send, then mark those sent and give the rest back
+  sentIds := send(notifications)
+  err = db.MarkNotificationsSent(n.DBConn, sentIds)
+  if err != nil {
+    return err
+  }
+  sent := map[int]bool{}
+  for _, id := range sentIds {
+    sent[id] = true
+  }
+  unsentIds := []int{}
+  for _, notification := range notifications {
+    if !sent[notification.Id] {
+      unsentIds = append(unsentIds, notification.Id)
+    }
+  }
+  return db.ReleaseNotifications(n.DBConn, unsentIds)
+}
+
+func (n *Notifier) send(email string, notifications []data.Notification) error {
+  var auth smtp.Auth
+  if len(n.Smtp.Username) > 0 {
+    host, _, err := net.SplitHostPort(n.Smtp.Addr)
+    if err != nil {
+      return err
+    }
+    auth = smtp.PlainAuth("", n.Smtp.Username, n.Smtp.Password, host)
+  }
+  return n.Send(n.Smtp.Addr, auth, n.Smtp.From, []string{email}, Message(n.Smtp.From, email, n.UnsubscribeUrl, notifications))
+}
+
+func SendMail(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+  // as smtp.SendMail, but gives up on a relay that doesn't answer in
+  // time rather than holding up the rest of the batch
+  host, _, err := net.SplitHostPort(addr)
+  if err != nil {
+    return err
+  }
+  conn, err := net.DialTimeout("tcp", addr, SendTimeout)
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+  err = conn.SetDeadline(time.Now().Add(SendTimeout))
+  if err != nil {
+    return err
+  }
+  c, err := smtp.NewClient(conn, host)
+  if err != nil {
+    return err
+  }
+  defer c.Close()
+  if ok, _ := c.Extension("STARTTLS"); ok {
+    err = c.StartTLS(&tls.Config{ServerName: host})
+    if err != nil {
+      return err
+    }
+  }
+  if a != nil {
+    if ok, _ := c.Extension("AUTH"); !ok {
+      return errors.New("smtp: server doesn't support AUTH")
+    }
+    err = c.Auth(a)
+    if err != nil {
+      return err
+    }
+  }
+  err = c.Mail(from)
+  if err != nil {
+    return err
+  }
+  for _, recipient := range to {
+    err = c.Rcpt(recipient)
+    if err != nil {
+      return err
+    }
+  }
+  w, err := c.Data()
+  if err != nil {
+    return err
+  }
+  _, err = w.Write(msg)
+  if err != nil {
+    return err
+  }
+  err = w.Close()
+  if err != nil {
+    return err
+  }
+  return c.Quit()
+}
+
+func Message(from, to, unsubscribeUrl string, notifications []data.Notification) []byte {
+  // writes a plain text digest of status changes, ending with a link
+  // to unsubscribe from each of the subscriptions that led to it
+  var body bytes.Buffer
+  fmt.Fprintf(&body, "The following status changes have been made to the SLEM / CECS Register.\r\n\r\n")
+  tokens := []string{}
+  subscribed := map[string]data.Notification{}
+  for _, notification := range notifications {
+    fmt.Fprintf(&body, "%s  %s\r\n", notification.Stamp, notification.Action)
+    if notification.DogName != notification.SubscribedName {
+      fmt.Fprintf(&body, "    (descendant of %s)\r\n", notification.SubscribedName)
+    }
+    fmt.Fprintf(&body, "    by %s\r\n", notification.Actor)
+    if _, ok := subscribed[notification.Token]; !ok {
+      tokens = append(tokens, notification.Token)
+      subscribed[notification.Token] = notification
+    }
+  }
+  fmt.Fprintf(&body, "\r\n")
+  for _, token := range tokens {
+    what := subscribed[token].SubscribedName
+    if subscribed[token].Descendants {
+      what += " and its descendants"
+    }
+    fmt.Fprintf(&body, "To stop emails about %s: %s%s\r\n", what, unsubscribeUrl, url.QueryEscape(token))
+  }
+
+  // headers
+  var msg bytes.Buffer
+  fmt.Fprintf(&msg, "From: %s\r\n", from)
+  fmt.Fprintf(&msg, "To: %s\r\n", to)
+  fmt.Fprintf(&msg, "Subject: SLEM / CECS Register: %d status change(s)\r\n", len(notifications))
+  fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+  if len(tokens) == 1 {
+    // mail clients can unsubscribe with a single POST (RFC 8058)
+    fmt.Fprintf(&msg, "List-Unsubscribe: <%s%s>\r\n", unsubscribeUrl, url.QueryEscape(tokens[0]))
+    fmt.Fprintf(&msg, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+  }
+  fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+  fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+  fmt.Fprintf(&msg, "Content-Transfer-Encoding: 8bit\r\n")
+  fmt.Fprintf(&msg, "\r\n")
+  msg.Write(body.Bytes())
+  return msg.Bytes()
+}
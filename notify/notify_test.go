@@ -0,0 +1,271 @@
+package notify
+
+import (
+  "bufio"
+  "net"
+  "strings"
+  "sync"
+  "testing"
+
+  "bitbucket.org/Rusty1958/shakingdog/config"
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+const testUnsubscribeUrl = "https://register.example.com/api/unsubscribe?token="
+
+// a local SMTP stand-in, enough for smtp.SendMail, that keeps every
+// message it's given and refuses recipients it's told to
+type smtpMessage struct {
+  to []string
+  content string
+}
+
+type smtpServer struct {
+  listener net.Listener
+  lock sync.Mutex
+  refuse map[string]bool
+  messages []smtpMessage
+}
+
+func newSmtpServer(t *testing.T) *smtpServer {
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  s := &smtpServer{listener: listener, refuse: map[string]bool{}}
+  go func() {
+    for {
+      conn, err := listener.Accept()
+      if err != nil {
+        return
+      }
+      go s.serve(conn)
+    }
+  }()
+  return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+  defer conn.Close()
+  reader := bufio.NewReader(conn)
+  reply := func(line string) {
+    conn.Write([]byte(line + "\r\n"))
+  }
+  reply("220 localhost ESMTP")
+  var message smtpMessage
+  var content strings.Builder
+  inData := false
+  for {
+    line, err := reader.ReadString('\n')
+    if err != nil {
+      return
+    }
+    if inData {
+      if line == ".\r\n" {
+        inData = false
+        message.content = content.String()
+        s.lock.Lock()
+        s.messages = append(s.messages, message)
+        s.lock.Unlock()
+        reply("250 Queued")
+        continue
+      }
+      content.WriteString(strings.TrimPrefix(line, "."))
+      continue
+    }
+    command := strings.ToUpper(strings.TrimSpace(line))
+    switch {
+    case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+      reply("250 localhost")
+    case strings.HasPrefix(command, "MAIL FROM:"):
+      message = smtpMessage{}
+      content.Reset()
+      reply("250 OK")
+    case strings.HasPrefix(command, "RCPT TO:"):
+      to := strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+      s.lock.Lock()
+      refused := s.refuse[to]
+      s.lock.Unlock()
+      if refused {
+        reply("550 Mailbox unavailable")
+        continue
+      }
+      message.to = append(message.to, to)
+      reply("250 OK")
+    case command == "DATA":
+      inData = true
+      reply("354 End data with <CR><LF>.<CR><LF>")
+    case command == "QUIT":
+      reply("221 Bye")
+      return
+    default:
+      reply("250 OK")
+    }
+  }
+}
+
+func (s *smtpServer) Refuse(email string, refuse bool) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  s.refuse[email] = refuse
+}
+
+func (s *smtpServer) Messages() []smtpMessage {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  return append([]smtpMessage{}, s.messages...)
+}
+
+// notifications kept in memory, handed out and marked as sent the same
+// way as by the database
+type testStore struct {
+  notifications []data.Notification
+  sent map[int]bool
+}
+
+func (store *testStore) Batch(send func([]data.Notification) []int) error {
+  unsent := []data.Notification{}
+  for _, notification := range store.notifications {
+    if !store.sent[notification.Id] {
+      unsent = append(unsent, notification)
+    }
+  }
+  for _, id := range send(unsent) {
+    store.sent[id] = true
+  }
+  return nil
+}
+
+func newTestNotifier(server *smtpServer, notifications []data.Notification) (*Notifier, *testStore) {
+  store := &testStore{notifications: notifications, sent: map[int]bool{}}
+  n := NewNotifier(nil, &config.Smtp{
+    Addr: server.listener.Addr().String(),
+    From: "register@example.com",
+  }, testUnsubscribeUrl)
+  n.Batch = store.Batch
+  return n, store
+}
+
+func testNotification(id int, email, token, dogName string) data.Notification {
+  return data.Notification{
+    Id: id,
+    Email: email,
+    Token: token,
+    SubscribedName: dogName,
+    DogName: dogName,
+    Stamp: "2020-01-01 10:00:00",
+    Actor: "someone@example.com",
+    Action: "Updated SLEM status; Name = '" + dogName + "'; Status 'Unknown' => 'Clear'",
+  }
+}
+
+func TestBatchByRecipient(t *testing.T) {
+  server := newSmtpServer(t)
+  defer server.listener.Close()
+  n, store := newTestNotifier(server, []data.Notification{
+    testNotification(1, "a@example.com", "token-a", "Rex"),
+    testNotification(2, "b@example.com", "token-b", "Fido"),
+    testNotification(3, "a@example.com", "token-a", "Rex"),
+    testNotification(4, "a@example.com", "token-a2", "Spot"),
+  })
+
+  sent, err := n.SendBatch()
+  if err != nil || sent != 2 {
+    t.Fatalf("SendBatch = %d, %v", sent, err)
+  }
+  messages := server.Messages()
+  if len(messages) != 2 {
+    t.Fatalf("%d emails sent, want 2", len(messages))
+  }
+  byRecipient := map[string]string{}
+  for _, message := range messages {
+    if len(message.to) != 1 {
+      t.Fatalf("email sent to %v", message.to)
+    }
+    byRecipient[message.to[0]] = message.content
+  }
+  if content := byRecipient["a@example.com"]; !strings.Contains(content, "Subject: SLEM / CECS Register: 3 status change(s)") ||
+    strings.Count(content, "Name = 'Rex'") != 2 || strings.Count(content, "Name = 'Spot'") != 1 || strings.Contains(content, "Fido") {
+    t.Errorf("email to a@example.com:\n%s", content)
+  }
+  if content := byRecipient["b@example.com"]; !strings.Contains(content, "Subject: SLEM / CECS Register: 1 status change(s)") ||
+    !strings.Contains(content, "Name = 'Fido'") || strings.Contains(content, "Rex") {
+    t.Errorf("email to b@example.com:\n%s", content)
+  }
+  for id := 1; id <= 4; id++ {
+    if !store.sent[id] {
+      t.Errorf("notification %d not marked as sent", id)
+    }
+  }
+
+  // nothing more to send
+  if sent, _ = n.SendBatch(); sent != 0 || len(server.Messages()) != 2 {
+    t.Errorf("second batch sent %d", sent)
+  }
+}
+
+func TestFailedSendRetried(t *testing.T) {
+  server := newSmtpServer(t)
+  defer server.listener.Close()
+  n, store := newTestNotifier(server, []data.Notification{
+    testNotification(1, "a@example.com", "token-a", "Rex"),
+    testNotification(2, "b@example.com", "token-b", "Fido"),
+  })
+
+  server.Refuse("b@example.com", true)
+  if sent, err := n.SendBatch(); err != nil || sent != 1 {
+    t.Fatalf("SendBatch = %d, %v", sent, err)
+  }
+  if !store.sent[1] || store.sent[2] {
+    t.Fatalf("sent = %v, want only 1", store.sent)
+  }
+
+  // the refused email goes with the next batch, along with anything new
+  server.Refuse("b@example.com", false)
+  store.notifications = append(store.notifications, testNotification(3, "b@example.com", "token-b", "Fido"))
+  if sent, err := n.SendBatch(); err != nil || sent != 1 {
+    t.Fatalf("next SendBatch = %d, %v", sent, err)
+  }
+  messages := server.Messages()
+  if len(messages) != 2 || messages[1].to[0] != "b@example.com" || strings.Count(messages[1].content, "Name = 'Fido'") != 2 {
+    t.Fatalf("emails = %+v", messages)
+  }
+  if !store.sent[2] || !store.sent[3] {
+    t.Errorf("sent = %v, want all", store.sent)
+  }
+}
+
+func TestUnsubscribeLinks(t *testing.T) {
+  // one subscription, which mail clients can unsubscribe from directly
+  single := string(Message("register@example.com", "a@example.com", testUnsubscribeUrl, []data.Notification{
+    testNotification(1, "a@example.com", "token/a", "Rex"),
+    testNotification(2, "a@example.com", "token/a", "Rex"),
+  }))
+  link := testUnsubscribeUrl + "token%2Fa"
+  if strings.Count(single, "To stop emails about Rex: " + link + "\r\n") != 1 {
+    t.Errorf("unsubscribe link missing:\n%s", single)
+  }
+  if !strings.Contains(single, "List-Unsubscribe: <" + link + ">\r\n") ||
+    !strings.Contains(single, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n") {
+    t.Errorf("unsubscribe headers missing:\n%s", single)
+  }
+
+  // several, which each get their own link but no header
+  descendant := testNotification(3, "a@example.com", "token-b", "Pup")
+  descendant.SubscribedName = "Fido"
+  descendant.Descendants = true
+  several := string(Message("register@example.com", "a@example.com", testUnsubscribeUrl, []data.Notification{
+    testNotification(1, "a@example.com", "token/a", "Rex"),
+    descendant,
+  }))
+  if !strings.Contains(several, "To stop emails about Rex: " + link + "\r\n") ||
+    !strings.Contains(several, "To stop emails about Fido and its descendants: " + testUnsubscribeUrl + "token-b\r\n") {
+    t.Errorf("unsubscribe links missing:\n%s", several)
+  }
+  if !strings.Contains(several, "(descendant of Fido)") {
+    t.Errorf("descendant not explained:\n%s", several)
+  }
+  if strings.Contains(several, "List-Unsubscribe") {
+    t.Errorf("unsubscribe header with several subscriptions:\n%s", several)
+  }
+}
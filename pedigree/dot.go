@@ -0,0 +1,101 @@
+package pedigree
+
+import (
+  "bufio"
+  "fmt"
+  "html"
+  "io"
+)
+
+// fill colours for each status, shared by the DOT and SVG output
+var statusColours = map[string]string{
+  "Affected": "#e57373",
+  "Carrier": "#ffb74d",
+  "CarrierByProgeny": "#ffe0b2",
+  "Clear": "#81c784",
+  "ClearByParentage": "#c8e6c9",
+  "Unknown": "#e0e0e0",
+}
+
+// short forms of statuses, for the CECS badge
+var statusAbbreviations = map[string]string{
+  "Affected": "Aff",
+  "Carrier": "Car",
+  "CarrierByProgeny": "PbyP",
+  "Clear": "Clr",
+  "ClearByParentage": "CbyP",
+  "Unknown": "Unk",
+}
+
+
+func (g *Graph) WriteDot(w io.Writer) error {
+  // writes the graph in Graphviz DOT format, filled by SLEM status with
+  // a CECS badge next to the name and one rank per generation
+  bw := bufio.NewWriter(w)
+  fmt.Fprintln(bw, "digraph pedigree {")
+  fmt.Fprintln(bw, "  rankdir=TB;")
+  fmt.Fprintln(bw, "  node [style=filled, fontname=\"Helvetica\", fontsize=10];")
+  fmt.Fprintln(bw, "  edge [arrowhead=none];")
+
+  first, last, byGeneration := g.Generations()
+  for generation := first; generation <= last; generation++ {
+    fmt.Fprintln(bw, "  { rank=same;")
+    for _, node := range byGeneration[generation] {
+      fmt.Fprintf(bw, "    d%d [%s];\n", node.Dog.Id, dotAttributes(node, node.Dog.Id == g.DogId))
+    }
+    fmt.Fprintln(bw, "  }")
+  }
+  for _, edge := range g.Edges {
+    fmt.Fprintf(bw, "  d%d -> d%d;\n", edge.ParentId, edge.ChildId)
+  }
+  fmt.Fprintln(bw, "}")
+  return bw.Flush()
+}
+
+func dotAttributes(node GraphNode, isDog bool) string {
+  // dogs are boxes and bitches are ellipses, as in a pedigree chart
+  shape := "box"
+  if node.Dog.Gender == "B" {
+    shape = "ellipse"
+  } else if node.Dog.Gender != "D" {
+    shape = "diamond"
+  }
+  style := "filled"
+  if node.Mate {
+    style = "filled,dashed"
+  }
+  penWidth := 1
+  if isDog {
+    penWidth = 3
+  }
+  label := fmt.Sprintf(
+    "<<TABLE BORDER=\"0\" CELLSPACING=\"2\"><TR><TD>%s</TD><TD BGCOLOR=\"%s\" BORDER=\"1\">CECS %s</TD></TR></TABLE>>",
+    html.EscapeString(node.Dog.Name),
+    statusColour(node.Dog.CecsStatus),
+    statusAbbreviation(node.Dog.CecsStatus),
+  )
+  return fmt.Sprintf("label=%s, shape=%s, style=\"%s\", fillcolor=\"%s\", penwidth=%d, tooltip=\"%s\"",
+    label,
+    shape,
+    style,
+    statusColour(node.Dog.ShakingDogStatus),
+    penWidth,
+    html.EscapeString("SLEM " + node.Dog.ShakingDogStatus + ", CECS " + node.Dog.CecsStatus),
+  )
+}
+
+func statusColour(status string) string {
+  colour, ok := statusColours[status]
+  if !ok {
+    return statusColours["Unknown"]
+  }
+  return colour
+}
+
+func statusAbbreviation(status string) string {
+  abbreviation, ok := statusAbbreviations[status]
+  if !ok {
+    return statusAbbreviations["Unknown"]
+  }
+  return abbreviation
+}
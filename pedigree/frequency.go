@@ -0,0 +1,163 @@
+package pedigree
+
+import (
+  "math"
+  "sort"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+const (
+  SampleAll = "all"
+  SampleFounders = "founders"
+  SampleUnrelated = "unrelated"
+
+  // z-score for a two-sided 95% confidence interval
+  confidenceLevel = 0.95
+  confidenceZ = 1.959964
+)
+
+// number of mutant alleles carried for each lab-confirmed status
+// NOTE: inferred statuses are excluded as they are derived from
+//       other dogs and would double-count the same evidence
+var mutantAlleles = map[string]int{
+  "Clear": 0,
+  "Carrier": 1,
+  "Affected": 2,
+}
+
+
+func IsValidSample(sample string) bool {
+  return data.StringInSlice([]string{SampleAll, SampleFounders, SampleUnrelated}, sample)
+}
+
+func (p *Pedigree) AlleleFrequencies(sample string) data.AlleleFrequencyReport {
+  // estimates the mutant allele frequency and carrier rate of each
+  // ailment from lab-confirmed statuses in the chosen sample of dogs
+  return data.AlleleFrequencyReport{
+    Sample: sample,
+    Confidence: confidenceLevel,
+    Ailments: []data.AilmentFrequency{
+      p.ailmentFrequency("SLEM", sample, func(dog data.Dog) string { return dog.ShakingDogStatus }),
+      p.ailmentFrequency("CECS", sample, func(dog data.Dog) string { return dog.CecsStatus }),
+    },
+  }
+}
+
+func (p *Pedigree) ailmentFrequency(ailment, sample string, status func(data.Dog) string) data.AilmentFrequency {
+  // FIRST, find the lab-tested dogs
+  tested := []int{}
+  for _, id := range p.SortedIds() {
+    if _, ok := mutantAlleles[status(p.Dogs[id])]; ok {
+      tested = append(tested, id)
+    }
+  }
+
+  // THEN, reduce to the requested sample to limit the bias from
+  // tested dogs being closely related to each other
+  switch sample {
+  case SampleFounders:
+    founders := []int{}
+    for _, id := range tested {
+      if p.IsFounder(id) {
+        founders = append(founders, id)
+      }
+    }
+    tested = founders
+  case SampleUnrelated:
+    tested = p.unrelated(tested)
+  }
+
+  // count genotypes
+  result := data.AilmentFrequency{Ailment: ailment, Tested: len(tested)}
+  alleles := 0
+  for _, id := range tested {
+    switch status(p.Dogs[id]) {
+    case "Affected":
+      result.Affected++
+    case "Carrier":
+      result.Carriers++
+    case "Clear":
+      result.Clear++
+    }
+    alleles += mutantAlleles[status(p.Dogs[id])]
+  }
+
+  // each dog contributes two alleles
+  result.AlleleFrequency = wilson(alleles, 2 * len(tested))
+  result.CarrierRate = wilson(result.Carriers, len(tested))
+
+  result.ExpectedCarrierRate = expectedCarrierRate(result.AlleleFrequency)
+  return result
+}
+
+func (p *Pedigree) unrelated(ids []int) []int {
+  // greedily picks dogs that share no ancestors with, and are not
+  // ancestors of, any dog already picked
+  // NOTE: dogs with the shallowest pedigrees are considered first as
+  //       they exclude the fewest others
+  ancestors := map[int]map[int]int{}
+  for _, id := range ids {
+    ancestors[id] = p.Ancestors(id, 0)
+  }
+  ordered := append([]int{}, ids...)
+  sort.SliceStable(ordered, func(i, j int) bool {
+    return len(ancestors[ordered[i]]) < len(ancestors[ordered[j]])
+  })
+
+  picked := []int{}
+  used := map[int]bool{}
+  for _, id := range ordered {
+    related := used[id]
+    for ancestorId, _ := range ancestors[id] {
+      related = related || used[ancestorId]
+    }
+    if related {
+      continue
+    }
+    picked = append(picked, id)
+    used[id] = true
+    for ancestorId, _ := range ancestors[id] {
+      used[ancestorId] = true
+    }
+  }
+  sort.Ints(picked)
+  return picked
+}
+
+func expectedCarrierRate(q data.Estimate) data.Estimate {
+  // carrier rate under Hardy-Weinberg equilibrium (2pq), which rises to
+  // 0.5 at q = 0.5 and falls again after, so the interval runs from the
+  // lower of its ends up to the peak if the allele interval spans it
+  carriers := func(q float64) float64 {
+    return 2 * q * (1 - q)
+  }
+  rate := data.Estimate{
+    Value: carriers(q.Value),
+    Lower: math.Min(carriers(q.Lower), carriers(q.Upper)),
+    Upper: math.Max(carriers(q.Lower), carriers(q.Upper)),
+  }
+  if q.Lower <= 0.5 && q.Upper >= 0.5 {
+    rate.Upper = 0.5
+  }
+  return rate
+}
+
+func wilson(successes, trials int) data.Estimate {
+  // proportion with a Wilson score confidence interval, which
+  // behaves sensibly for small samples and proportions near zero
+  if trials == 0 {
+    return data.Estimate{}
+  }
+  n := float64(trials)
+  phat := float64(successes) / n
+  z2 := confidenceZ * confidenceZ
+  denom := 1 + z2 / n
+  centre := (phat + z2 / (2 * n)) / denom
+  half := confidenceZ * math.Sqrt(phat * (1 - phat) / n + z2 / (4 * n * n)) / denom
+  return data.Estimate{
+    Value: phat,
+    Lower: math.Max(0, centre - half),
+    Upper: math.Min(1, centre + half),
+  }
+}
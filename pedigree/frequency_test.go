@@ -0,0 +1,59 @@
+package pedigree
+
+import (
+  "math"
+  "testing"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+func closeTo(a, b data.Estimate) bool {
+  return math.Abs(a.Value - b.Value) < 1e-6 &&
+    math.Abs(a.Lower - b.Lower) < 1e-6 &&
+    math.Abs(a.Upper - b.Upper) < 1e-6
+}
+
+func TestWilson(t *testing.T) {
+  tests := []struct {
+    successes int
+    trials int
+    want data.Estimate
+  }{
+    // nothing tested
+    {0, 0, data.Estimate{}},
+    // proportions at either end still get an interval
+    {0, 10, data.Estimate{Value: 0, Lower: 0, Upper: 0.277533}},
+    {10, 10, data.Estimate{Value: 1, Lower: 0.722467, Upper: 1}},
+    // and ones in between aren't symmetric about the value
+    {5, 10, data.Estimate{Value: 0.5, Lower: 0.236593, Upper: 0.763407}},
+    {1, 20, data.Estimate{Value: 0.05, Lower: 0.008881, Upper: 0.236131}},
+    {3, 4, data.Estimate{Value: 0.75, Lower: 0.300642, Upper: 0.954413}},
+  }
+  for _, test := range tests {
+    if got := wilson(test.successes, test.trials); !closeTo(got, test.want) {
+      t.Errorf("wilson(%d, %d) = %+v, want %+v", test.successes, test.trials, got, test.want)
+    }
+  }
+}
+
+func TestExpectedCarrierRate(t *testing.T) {
+  tests := []struct {
+    q data.Estimate
+    want data.Estimate
+  }{
+    {data.Estimate{}, data.Estimate{}},
+    // below 0.5 the ends map across
+    {data.Estimate{Value: 0.2, Lower: 0.1, Upper: 0.3}, data.Estimate{Value: 0.32, Lower: 0.18, Upper: 0.42}},
+    // spanning 0.5 peaks there, and the lower end can come from either side
+    {data.Estimate{Value: 0.5, Lower: 0.4, Upper: 0.6}, data.Estimate{Value: 0.5, Lower: 0.48, Upper: 0.5}},
+    {data.Estimate{Value: 0.6, Lower: 0.3, Upper: 0.9}, data.Estimate{Value: 0.48, Lower: 0.18, Upper: 0.5}},
+    // above 0.5 the ends swap over
+    {data.Estimate{Value: 0.7, Lower: 0.6, Upper: 0.8}, data.Estimate{Value: 0.42, Lower: 0.32, Upper: 0.48}},
+    {data.Estimate{Value: 1, Lower: 0.722467, Upper: 1}, data.Estimate{Value: 0, Lower: 0, Upper: 0.401017}},
+  }
+  for _, test := range tests {
+    if got := expectedCarrierRate(test.q); !closeTo(got, test.want) {
+      t.Errorf("expectedCarrierRate(%+v) = %+v, want %+v", test.q, got, test.want)
+    }
+  }
+}
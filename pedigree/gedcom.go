@@ -0,0 +1,118 @@
+package pedigree
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "sort"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// GEDCOM sex codes for each gender
+var gedcomSexes = map[string]string{
+  "D": "M",
+  "B": "F",
+  "U": "U",
+}
+
+
+func (p *Pedigree) PedigreeIds(dogId, depth int) []int {
+  // returns a dog and its ancestors, up to depth generations back
+  ids := []int{dogId}
+  for id, _ := range p.Ancestors(dogId, depth) {
+    ids = append(ids, id)
+  }
+  sort.Ints(ids)
+  return ids
+}
+
+func (p *Pedigree) Families(dogIds []int) []data.Family {
+  // groups the given dogs into families, i.e. a Sire and Dam with all
+  // of their children, where all three are among the given dogs
+  included := map[int]bool{}
+  for _, id := range dogIds {
+    included[id] = true
+  }
+  families := []data.Family{}
+  index := map[[2]int]int{}
+  for _, id := range dogIds {
+    sireId, damId, ok := p.Parents(id)
+    if !ok || !included[sireId] || !included[damId] {
+      continue
+    }
+    couple := [2]int{sireId, damId}
+    i, ok := index[couple]
+    if !ok {
+      i = len(families)
+      index[couple] = i
+      families = append(families, data.Family{
+        Sire: p.Dogs[sireId],
+        Dam: p.Dogs[damId],
+        Children: []data.Dog{},
+      })
+    }
+    families[i].Children = append(families[i].Children, p.Dogs[id])
+  }
+  return families
+}
+
+func (p *Pedigree) WriteGedcom(w io.Writer, dogIds []int) error {
+  // writes the given dogs as GEDCOM individuals, with a family record
+  // for each litter, so they can be read by other registers
+  // NOTE: statuses have no standard GEDCOM tag, so use custom ones
+  families := p.Families(dogIds)
+  famc := map[int]int{}
+  fams := map[int][]int{}
+  for i, family := range families {
+    fams[family.Sire.Id] = append(fams[family.Sire.Id], i + 1)
+    fams[family.Dam.Id] = append(fams[family.Dam.Id], i + 1)
+    for _, child := range family.Children {
+      famc[child.Id] = i + 1
+    }
+  }
+
+  bw := bufio.NewWriter(w)
+  fmt.Fprintln(bw, "0 HEAD")
+  fmt.Fprintln(bw, "1 SOUR SHAKINGDOG")
+  fmt.Fprintln(bw, "1 GEDC")
+  fmt.Fprintln(bw, "2 VERS 5.5.1")
+  fmt.Fprintln(bw, "2 FORM LINEAGE-LINKED")
+  fmt.Fprintln(bw, "1 CHAR UTF-8")
+  for _, id := range dogIds {
+    dog := p.Dogs[id]
+    sex, ok := gedcomSexes[dog.Gender]
+    if !ok {
+      sex = "U"
+    }
+    fmt.Fprintf(bw, "0 @I%d@ INDI\n", dog.Id)
+    fmt.Fprintf(bw, "1 NAME %s\n", gedcomValue(dog.Name))
+    fmt.Fprintf(bw, "1 SEX %s\n", sex)
+    fmt.Fprintf(bw, "1 _SLEM %s\n", dog.ShakingDogStatus)
+    fmt.Fprintf(bw, "1 _CECS %s\n", dog.CecsStatus)
+    if i, ok := famc[dog.Id]; ok {
+      fmt.Fprintf(bw, "1 FAMC @F%d@\n", i)
+    }
+    for _, i := range fams[dog.Id] {
+      fmt.Fprintf(bw, "1 FAMS @F%d@\n", i)
+    }
+  }
+  for i, family := range families {
+    fmt.Fprintf(bw, "0 @F%d@ FAM\n", i + 1)
+    fmt.Fprintf(bw, "1 HUSB @I%d@\n", family.Sire.Id)
+    fmt.Fprintf(bw, "1 WIFE @I%d@\n", family.Dam.Id)
+    for _, child := range family.Children {
+      fmt.Fprintf(bw, "1 CHIL @I%d@\n", child.Id)
+    }
+  }
+  fmt.Fprintln(bw, "0 TRLR")
+  return bw.Flush()
+}
+
+func gedcomValue(value string) string {
+  // GEDCOM uses slashes to mark a surname and @ for cross references,
+  // neither of which are meant in a registered name
+  value = strings.Replace(value, "@", "@@", -1)
+  return strings.Replace(value, "/", "", -1)
+}
@@ -0,0 +1,109 @@
+package pedigree
+
+import (
+  "sort"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+const (
+  DefaultGraphDepth = 3
+  MaxGraphDepth = 8
+)
+
+// a dog in a pedigree graph, where the generation is relative to the
+// dog the graph is drawn for (-1 = parent, 0 = dog, 1 = child, ...)
+// NOTE: a mate is the other parent of a descendant, included so that
+//       every litter is drawn with both parents
+type GraphNode struct {
+  Dog data.Dog
+  Generation int
+  Mate bool
+}
+
+type GraphEdge struct {
+  ParentId int
+  ChildId int
+}
+
+type Graph struct {
+  DogId int
+  Nodes []GraphNode
+  Edges []GraphEdge
+}
+
+
+func (p *Pedigree) Graph(dogId, depth int) *Graph {
+  // builds the graph of a dog's ancestors and descendants, up to depth
+  // generations each way, with nodes in the order they should be drawn
+  // within each generation
+  g := &Graph{DogId: dogId, Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+  seen := map[int]bool{dogId: true}
+  g.Nodes = append(g.Nodes, GraphNode{Dog: p.Dogs[dogId]})
+
+  // FIRST, ancestors, breadth first so each sire is drawn before its dam
+  current := []int{dogId}
+  for generation := 1; len(current) > 0 && generation <= depth; generation++ {
+    next := []int{}
+    for _, id := range current {
+      sireId, damId, ok := p.Parents(id)
+      if !ok {
+        continue
+      }
+      for _, parentId := range []int{sireId, damId} {
+        g.Edges = append(g.Edges, GraphEdge{ParentId: parentId, ChildId: id})
+        if seen[parentId] {
+          continue
+        }
+        seen[parentId] = true
+        g.Nodes = append(g.Nodes, GraphNode{Dog: p.Dogs[parentId], Generation: -generation})
+        next = append(next, parentId)
+      }
+    }
+    current = next
+  }
+
+  // THEN, descendants, with each litter's other parent drawn alongside
+  current = []int{dogId}
+  for generation := 1; len(current) > 0 && generation <= depth; generation++ {
+    next := []int{}
+    for _, id := range current {
+      children := append([]int{}, p.Children(id)...)
+      sort.Ints(children)
+      for _, childId := range children {
+        sireId, damId, _ := p.Parents(childId)
+        mateId := sireId
+        if mateId == id {
+          mateId = damId
+        }
+        if !seen[mateId] {
+          seen[mateId] = true
+          g.Nodes = append(g.Nodes, GraphNode{Dog: p.Dogs[mateId], Generation: generation - 1, Mate: true})
+        }
+        if seen[childId] {
+          continue
+        }
+        seen[childId] = true
+        g.Nodes = append(g.Nodes, GraphNode{Dog: p.Dogs[childId], Generation: generation})
+        g.Edges = append(g.Edges,
+          GraphEdge{ParentId: sireId, ChildId: childId},
+          GraphEdge{ParentId: damId, ChildId: childId},
+        )
+        next = append(next, childId)
+      }
+    }
+    current = next
+  }
+  return g
+}
+
+func (g *Graph) Generations() (first, last int, byGeneration map[int][]GraphNode) {
+  // groups the nodes by generation, keeping the drawing order
+  byGeneration = map[int][]GraphNode{}
+  for _, node := range g.Nodes {
+    byGeneration[node.Generation] = append(byGeneration[node.Generation], node)
+    first = data.Min(first, node.Generation)
+    last = data.Max(last, node.Generation)
+  }
+  return
+}
@@ -0,0 +1,57 @@
+package pedigree
+
+import (
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// statuses that inference never changes
+var labConfirmedStatuses = []string{"Affected", "Carrier", "Clear"}
+
+
+func InferLitter(sire, dam data.Dog, children []data.Dog) []data.InferredStatus {
+  // applies the SLEM inference rules of the inferupdate job to a single
+  // litter, returning the statuses that would change as a direct result
+  inferred := []data.InferredStatus{}
+
+  // children are ClearByParentage if both parents are clear
+  if data.StringInSlice(clearStatuses, sire.ShakingDogStatus) &&
+    data.StringInSlice(clearStatuses, dam.ShakingDogStatus) {
+    for _, child := range children {
+      if canInfer(child, "ClearByParentage") {
+        inferred = append(inferred, data.InferredStatus{
+          Dog: child,
+          Ailment: "SLEM",
+          Status: "ClearByParentage",
+        })
+      }
+    }
+  }
+
+  // a parent is CarrierByProgeny if any child is Affected, or if any
+  // child is Carrier and the other parent is clear
+  parents := []data.Dog{sire, dam}
+  for i, parent := range parents {
+    other := parents[1 - i]
+    otherIsClear := data.StringInSlice(clearStatuses, other.ShakingDogStatus)
+    for _, child := range children {
+      if child.ShakingDogStatus == "Affected" || (child.ShakingDogStatus == "Carrier" && otherIsClear) {
+        if canInfer(parent, "CarrierByProgeny") {
+          inferred = append(inferred, data.InferredStatus{
+            Dog: parent,
+            Ailment: "SLEM",
+            Status: "CarrierByProgeny",
+          })
+        }
+        break
+      }
+    }
+  }
+  return inferred
+}
+
+func canInfer(dog data.Dog, status string) bool {
+  // inference never overrides lab results or an admin's override
+  return dog.ShakingDogStatus != status &&
+    !data.StringInSlice(labConfirmedStatuses, dog.ShakingDogStatus) &&
+    !dog.ShakingDogInferOverride
+}
@@ -0,0 +1,148 @@
+package pedigree
+
+import (
+  "math"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+const (
+  DefaultKinshipDepth = 8
+  MaxKinshipDepth = 20
+  // lines of descent are only listed this far back, as their number
+  // doubles with every generation, and there can be no more than so many
+  MaxPathDepth = DefaultKinshipDepth
+  MaxKinshipPaths = 5000
+)
+
+type kinshipKey struct {
+  a int
+  b int
+  depth int
+}
+
+// Kinship calculates coefficients of coancestry over a pedigree,
+// tracing back no more than a fixed number of generations
+type Kinship struct {
+  p *Pedigree
+  depth int
+  memo map[kinshipKey]float64
+  generations map[int]int
+}
+
+
+func (p *Pedigree) NewKinship(depth int) *Kinship {
+  return &Kinship{
+    p: p,
+    depth: depth,
+    memo: map[kinshipKey]float64{},
+    generations: map[int]int{},
+  }
+}
+
+func (k *Kinship) Coancestry(a, b int) float64 {
+  // the probability that alleles drawn at random from each dog are
+  // identical by descent (also the inbreeding of their offspring)
+  return k.coancestry(a, b, k.depth)
+}
+
+func (k *Kinship) Inbreeding(dogId int) float64 {
+  // the coancestry of a dog's parents
+  sireId, damId, ok := k.p.Parents(dogId)
+  if !ok {
+    return 0
+  }
+  return k.coancestry(sireId, damId, k.depth - 1)
+}
+
+func (k *Kinship) Relationship(a, b int) float64 {
+  // Wright's coefficient of relationship
+  if a == b {
+    return 1
+  }
+  return 2 * k.Coancestry(a, b) / math.Sqrt((1 + k.Inbreeding(a)) * (1 + k.Inbreeding(b)))
+}
+
+func (k *Kinship) coancestry(a, b, depth int) float64 {
+  // recursive (tabular method) definition, always stepping up from the
+  // dog furthest from the founders as it cannot be an ancestor of the other
+  if depth <= 0 {
+    return 0
+  }
+  if a > b {
+    a, b = b, a
+  }
+  key := kinshipKey{a, b, depth}
+  if f, ok := k.memo[key]; ok {
+    return f
+  }
+
+  var f float64
+  if a == b {
+    f = 0.5
+    sireId, damId, ok := k.p.Parents(a)
+    if ok {
+      f = 0.5 * (1 + k.coancestry(sireId, damId, depth - 1))
+    }
+  } else {
+    younger, other := a, b
+    if k.generation(b, nil) > k.generation(a, nil) {
+      younger, other = b, a
+    }
+    sireId, damId, ok := k.p.Parents(younger)
+    if ok {
+      f = 0.5 * (k.coancestry(sireId, other, depth - 1) + k.coancestry(damId, other, depth - 1))
+    }
+  }
+  k.memo[key] = f
+  return f
+}
+
+func (k *Kinship) generation(dogId int, visiting map[int]bool) int {
+  // the longest line of descent from a founder to the dog
+  if g, ok := k.generations[dogId]; ok {
+    return g
+  }
+  sireId, damId, ok := k.p.Parents(dogId)
+  if !ok {
+    k.generations[dogId] = 0
+    return 0
+  }
+
+  // loop detection, in case of bad data
+  if visiting == nil {
+    visiting = map[int]bool{}
+  }
+  if visiting[dogId] {
+    return 0
+  }
+  visiting[dogId] = true
+  g := 1 + data.Max(k.generation(sireId, visiting), k.generation(damId, visiting))
+  delete(visiting, dogId)
+  k.generations[dogId] = g
+  return g
+}
+
+func (k *Kinship) Matrix(dogIds []int) (matrix [][]float64, meanKinship []float64) {
+  // pairwise coancestry of a group of dogs, plus each dog's mean
+  // kinship to the whole group (including itself)
+  matrix = make([][]float64, len(dogIds))
+  meanKinship = make([]float64, len(dogIds))
+  for i, _ := range dogIds {
+    matrix[i] = make([]float64, len(dogIds))
+  }
+  for i, _ := range dogIds {
+    for j := i; j < len(dogIds); j++ {
+      f := k.Coancestry(dogIds[i], dogIds[j])
+      matrix[i][j] = f
+      matrix[j][i] = f
+    }
+  }
+  for i, _ := range dogIds {
+    for j, _ := range dogIds {
+      meanKinship[i] += matrix[i][j]
+    }
+    meanKinship[i] /= float64(len(dogIds))
+  }
+  return
+}
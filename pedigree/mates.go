@@ -0,0 +1,61 @@
+package pedigree
+
+import (
+  "sort"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// statuses that cannot pass on a mutant allele
+var clearStatuses = []string{"Clear", "ClearByParentage"}
+
+
+func CouldProduceAffected(status1, status2 string) bool {
+  // a pup can only be Affected if both parents could pass on a
+  // mutant allele (Unknown is treated as a possible carrier)
+  return !data.StringInSlice(clearStatuses, status1) &&
+    !data.StringInSlice(clearStatuses, status2)
+}
+
+func (p *Pedigree) SuggestMates(dogId int, slemStatuses, cecsStatuses []string, depth int) []data.MateCandidate {
+  // lists opposite-gender dogs that cannot produce Affected pups for
+  // either ailment, least related first
+  // NOTE: empty status lists mean no filter
+  dog := p.Dogs[dogId]
+  gender := "B"
+  if dog.Gender == "B" {
+    gender = "D"
+  }
+
+  k := p.NewKinship(depth)
+  candidates := []data.MateCandidate{}
+  for _, id := range p.SortedIds() {
+    candidate := p.Dogs[id]
+    if candidate.Gender != gender {
+      continue
+    }
+    if len(slemStatuses) > 0 && !data.StringInSlice(slemStatuses, candidate.ShakingDogStatus) {
+      continue
+    }
+    if len(cecsStatuses) > 0 && !data.StringInSlice(cecsStatuses, candidate.CecsStatus) {
+      continue
+    }
+    if CouldProduceAffected(dog.ShakingDogStatus, candidate.ShakingDogStatus) ||
+      CouldProduceAffected(dog.CecsStatus, candidate.CecsStatus) {
+      continue
+    }
+    candidates = append(candidates, data.MateCandidate{
+      Dog: candidate,
+      Kinship: k.Coancestry(dogId, id),
+    })
+  }
+
+  sort.SliceStable(candidates, func(i, j int) bool {
+    if candidates[i].Kinship != candidates[j].Kinship {
+      return candidates[i].Kinship < candidates[j].Kinship
+    }
+    return strings.ToLower(candidates[i].Dog.Name) < strings.ToLower(candidates[j].Dog.Name)
+  })
+  return candidates
+}
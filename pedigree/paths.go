@@ -0,0 +1,126 @@
+package pedigree
+
+import (
+  "errors"
+  "math"
+  "sort"
+  "strings"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+var ErrTooManyPaths = errors.New("too many lines of descent")
+
+
+func (p *Pedigree) CommonAncestors(dog1Id, dog2Id, depth int) ([]data.CommonAncestor, error) {
+  // finds the ancestors shared by two dogs along with every line of
+  // descent through them that counts towards the dogs' coancestry,
+  // going back no further than MaxPathDepth whatever the depth given
+  // NOTE: a dog counts as its own "ancestor" so that a direct line
+  //       of descent between the two dogs is also found
+  // NOTE: the inbreeding of each ancestor still goes back the full depth
+  k := p.NewKinship(depth)
+  depth = data.Min(depth, MaxPathDepth)
+  up1 := p.upwardPaths(dog1Id, depth)
+  up2 := p.upwardPaths(dog2Id, depth)
+  count := 0
+
+  ancestors := []data.CommonAncestor{}
+  for ancestorId, paths1 := range up1 {
+    paths2, ok := up2[ancestorId]
+    if !ok {
+      continue
+    }
+    ancestor := data.CommonAncestor{Ancestor: p.Dogs[ancestorId], Paths: []data.KinshipPath{}}
+    for _, path1 := range paths1 {
+      for _, path2 := range paths2 {
+        // Wright's method only counts paths that meet at the ancestor
+        if !pathsMeetOnlyAt(path1, path2, ancestorId) {
+          continue
+        }
+        count++
+        if count > MaxKinshipPaths {
+          return nil, ErrTooManyPaths
+        }
+        n1 := len(path1) - 1
+        n2 := len(path2) - 1
+        path := data.KinshipPath{
+          Dogs: []data.Dog{},
+          Generations1: n1,
+          Generations2: n2,
+          Contribution: math.Pow(0.5, float64(n1 + n2 + 1)) * (1 + k.Inbreeding(ancestorId)),
+        }
+        for _, id := range path1 {
+          path.Dogs = append(path.Dogs, p.Dogs[id])
+        }
+        for i := len(path2) - 2; i >= 0; i-- {
+          path.Dogs = append(path.Dogs, p.Dogs[path2[i]])
+        }
+        ancestor.Paths = append(ancestor.Paths, path)
+      }
+    }
+
+    // ancestors only reached through a closer common ancestor are
+    // already accounted for, so leave them out
+    if len(ancestor.Paths) == 0 {
+      continue
+    }
+    sort.SliceStable(ancestor.Paths, func(i, j int) bool {
+      return len(ancestor.Paths[i].Dogs) < len(ancestor.Paths[j].Dogs)
+    })
+    ancestor.Generations1 = ancestor.Paths[0].Generations1
+    ancestor.Generations2 = ancestor.Paths[0].Generations2
+    for _, path := range ancestor.Paths {
+      ancestor.Generations1 = data.Min(ancestor.Generations1, path.Generations1)
+      ancestor.Generations2 = data.Min(ancestor.Generations2, path.Generations2)
+    }
+    ancestors = append(ancestors, ancestor)
+  }
+
+  // closest ancestors first
+  sort.SliceStable(ancestors, func(i, j int) bool {
+    gi := ancestors[i].Generations1 + ancestors[i].Generations2
+    gj := ancestors[j].Generations1 + ancestors[j].Generations2
+    if gi != gj {
+      return gi < gj
+    }
+    return strings.ToLower(ancestors[i].Ancestor.Name) < strings.ToLower(ancestors[j].Ancestor.Name)
+  })
+  return ancestors, nil
+}
+
+func (p *Pedigree) upwardPaths(dogId, depth int) map[int][][]int {
+  // maps the dog and each of its ancestors to every line of descent
+  // from the dog up to that ancestor, within the given depth
+  paths := map[int][][]int{}
+  var walk func(path []int)
+  walk = func(path []int) {
+    last := path[len(path) - 1]
+    paths[last] = append(paths[last], append([]int{}, path...))
+    if len(path) > depth {
+      return
+    }
+    sireId, damId, ok := p.Parents(last)
+    if !ok {
+      return
+    }
+    for _, parentId := range []int{sireId, damId} {
+      // loop detection, in case of bad data
+      if data.IntInSlice(path, parentId) {
+        continue
+      }
+      walk(append(path, parentId))
+    }
+  }
+  walk([]int{dogId})
+  return paths
+}
+
+func pathsMeetOnlyAt(path1, path2 []int, ancestorId int) bool {
+  for _, id := range path1 {
+    if id != ancestorId && data.IntInSlice(path2, id) {
+      return false
+    }
+  }
+  return true
+}
@@ -0,0 +1,193 @@
+package pedigree
+
+import (
+  "sort"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+
+// Pedigree is an in-memory copy of the register that allows the
+// relationship "tree" to be walked without a DB query per dog
+type Pedigree struct {
+  Dogs map[int]data.Dog
+  sires map[int]int
+  dams map[int]int
+  children map[int][]int
+}
+
+func New(dogs []data.Dog, rships []data.Relationship) *Pedigree {
+  // builds a pedigree from a list of dogs and their relationships
+  p := &Pedigree{
+    Dogs: map[int]data.Dog{},
+    sires: map[int]int{},
+    dams: map[int]int{},
+    children: map[int][]int{},
+  }
+  for _, dog := range dogs {
+    p.Dogs[dog.Id] = dog
+  }
+  for _, r := range rships {
+    childId := int(r.ChildId)
+    damId := int(r.DamId)
+    p.sires[childId] = r.SireId
+    p.dams[childId] = damId
+    p.children[r.SireId] = append(p.children[r.SireId], childId)
+    p.children[damId] = append(p.children[damId], childId)
+  }
+  return p
+}
+
+func Load(dbConn *db.Connection) (*Pedigree, error) {
+  // builds a pedigree from the current state of the register
+  dogs, err := db.GetDogs(dbConn)
+  if err != nil {
+    return nil, err
+  }
+  rships, err := db.GetRelationships(dbConn)
+  if err != nil {
+    return nil, err
+  }
+  return New(dogs, rships), nil
+}
+
+func LoadAsOf(dbConn *db.Connection, asOf string) (*Pedigree, error) {
+  // builds a pedigree from the register as it stood at a point in time
+  dogs, err := db.GetDogsAsOf(dbConn, asOf)
+  if err != nil {
+    return nil, err
+  }
+  rships, err := db.GetRelationshipsAsOf(dbConn, asOf)
+  if err != nil {
+    return nil, err
+  }
+  return New(dogs, rships), nil
+}
+
+func (p *Pedigree) Parents(dogId int) (sireId, damId int, ok bool) {
+  // returns the parents of a dog, ok is false for founders
+  sireId, ok = p.sires[dogId]
+  damId = p.dams[dogId]
+  return
+}
+
+func (p *Pedigree) Children(dogId int) []int {
+  // returns the children of a dog across all litters
+  return p.children[dogId]
+}
+
+func (p *Pedigree) IsFounder(dogId int) bool {
+  // a founder is a dog with no recorded parents
+  _, _, ok := p.Parents(dogId)
+  return !ok
+}
+
+func (p *Pedigree) Ancestors(dogId, depth int) map[int]int {
+  // returns all ancestors of a dog, mapped to the fewest generations
+  // between the dog and the ancestor (1 = parent, 2 = grandparent, ...)
+  // NOTE: depth <= 0 means no limit
+  ancestors := map[int]int{}
+  current := []int{dogId}
+  for generation := 1; len(current) > 0 && (depth <= 0 || generation <= depth); generation++ {
+    next := []int{}
+    for _, id := range current {
+      sireId, damId, ok := p.Parents(id)
+      if !ok {
+        continue
+      }
+      for _, parentId := range []int{sireId, damId} {
+        if _, seen := ancestors[parentId]; seen || parentId == dogId {
+          continue
+        }
+        ancestors[parentId] = generation
+        next = append(next, parentId)
+      }
+    }
+    current = next
+  }
+  return ancestors
+}
+
+func (p *Pedigree) SortedIds() []int {
+  // returns all dog IDs in ascending order, for stable output
+  ids := make([]int, 0, len(p.Dogs))
+  for id, _ := range p.Dogs {
+    ids = append(ids, id)
+  }
+  sort.Ints(ids)
+  return ids
+}
+
+func (p *Pedigree) AncestorTable(dogId, generations int) []*data.Dog {
+  // returns a dog and its ancestors laid out as in a pedigree chart,
+  // i.e. the Sire and Dam of the dog at index i are at 2i+1 and 2i+2,
+  // with nil for any ancestor that isn't recorded
+  table := make([]*data.Dog, (1 << uint(generations + 1)) - 1)
+  if dog, ok := p.Dogs[dogId]; ok {
+    table[0] = &dog
+  }
+  for i := 0; 2 * i + 2 < len(table); i++ {
+    if table[i] == nil {
+      continue
+    }
+    sireId, damId, ok := p.Parents(table[i].Id)
+    if !ok {
+      continue
+    }
+    sire := p.Dogs[sireId]
+    dam := p.Dogs[damId]
+    table[2 * i + 1] = &sire
+    table[2 * i + 2] = &dam
+  }
+  return table
+}
+
+func (p *Pedigree) DogFamilies(dogId int) (*data.Family, []data.Family) {
+  // returns the family a dog was born into (nil if unknown) and the
+  // families it parented, in the same form as db.GetFamilies
+  var familyAsChild *data.Family
+  if sireId, damId, ok := p.Parents(dogId); ok {
+    familyAsChild = &data.Family{
+      Sire: p.Dogs[sireId],
+      Dam: p.Dogs[damId],
+      Children: p.litter(sireId, damId),
+    }
+  }
+
+  // one family per mate
+  familiesAsParent := []data.Family{}
+  couples := [][2]int{}
+  seen := map[[2]int]bool{}
+  for _, childId := range p.Children(dogId) {
+    sireId, damId, _ := p.Parents(childId)
+    couple := [2]int{sireId, damId}
+    if !seen[couple] {
+      seen[couple] = true
+      couples = append(couples, couple)
+    }
+  }
+  // the dog is in every couple, so this orders them by mate
+  sort.Slice(couples, func(i, j int) bool {
+    return couples[i][0] + couples[i][1] < couples[j][0] + couples[j][1]
+  })
+  for _, couple := range couples {
+    familiesAsParent = append(familiesAsParent, data.Family{
+      Sire: p.Dogs[couple[0]],
+      Dam: p.Dogs[couple[1]],
+      Children: p.litter(couple[0], couple[1]),
+    })
+  }
+  return familyAsChild, familiesAsParent
+}
+
+func (p *Pedigree) litter(sireId, damId int) []data.Dog {
+  // returns all children of a Sire and Dam
+  children := []data.Dog{}
+  for _, childId := range p.Children(sireId) {
+    if _, childDamId, _ := p.Parents(childId); childDamId == damId {
+      children = append(children, p.Dogs[childId])
+    }
+  }
+  return children
+}
@@ -0,0 +1,116 @@
+package pedigree
+
+import (
+  "bufio"
+  "fmt"
+  "html"
+  "io"
+  "unicode/utf8"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+)
+
+// layout of the SVG drawing, in pixels
+const (
+  svgMargin = 20
+  svgNodeHeight = 40
+  svgNodeGap = 20
+  svgGenerationGap = 60
+  svgMinNodeWidth = 120
+  svgCharWidth = 7
+  svgBadgeWidth = 56
+  svgBadgeHeight = 16
+)
+
+type svgPosition struct {
+  x int
+  y int
+}
+
+
+func (g *Graph) WriteSvg(w io.Writer) error {
+  // draws the graph as SVG, laid out the same way as the DOT output:
+  // one row per generation with the oldest ancestors at the top
+  first, last, byGeneration := g.Generations()
+
+  // every node is sized to fit the longest name and its CECS badge
+  nodeWidth := svgMinNodeWidth
+  widest := 0
+  for _, node := range g.Nodes {
+    nodeWidth = data.Max(nodeWidth, utf8.RuneCountInString(node.Dog.Name) * svgCharWidth + svgBadgeWidth + 30)
+    widest = data.Max(widest, len(byGeneration[node.Generation]))
+  }
+  width := 2 * svgMargin + widest * nodeWidth + (widest - 1) * svgNodeGap
+  height := 2 * svgMargin + (last - first + 1) * svgNodeHeight + (last - first) * svgGenerationGap
+
+  // position each generation centred under the widest one
+  positions := map[int]svgPosition{}
+  for generation := first; generation <= last; generation++ {
+    nodes := byGeneration[generation]
+    rowWidth := len(nodes) * nodeWidth + (len(nodes) - 1) * svgNodeGap
+    x := (width - rowWidth) / 2
+    y := svgMargin + (generation - first) * (svgNodeHeight + svgGenerationGap)
+    for _, node := range nodes {
+      positions[node.Dog.Id] = svgPosition{x: x, y: y}
+      x += nodeWidth + svgNodeGap
+    }
+  }
+
+  bw := bufio.NewWriter(w)
+  fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"Helvetica, Arial, sans-serif\" font-size=\"12\">\n",
+    width, height, width, height)
+  fmt.Fprintf(bw, "  <rect width=\"%d\" height=\"%d\" fill=\"#ffffff\"/>\n", width, height)
+
+  // FIRST, edges, so nodes are drawn over them
+  for _, edge := range g.Edges {
+    from := positions[edge.ParentId]
+    to := positions[edge.ChildId]
+    x1 := from.x + nodeWidth / 2
+    y1 := from.y + svgNodeHeight
+    x2 := to.x + nodeWidth / 2
+    y2 := to.y
+    fmt.Fprintf(bw, "  <path d=\"M %d %d C %d %d, %d %d, %d %d\" fill=\"none\" stroke=\"#757575\"/>\n",
+      x1, y1, x1, (y1 + y2) / 2, x2, (y1 + y2) / 2, x2, y2)
+  }
+
+  // THEN, nodes
+  for _, node := range g.Nodes {
+    pos := positions[node.Dog.Id]
+    writeSvgNode(bw, node, pos, nodeWidth, node.Dog.Id == g.DogId)
+  }
+  fmt.Fprintln(bw, "</svg>")
+  return bw.Flush()
+}
+
+func writeSvgNode(bw *bufio.Writer, node GraphNode, pos svgPosition, nodeWidth int, isDog bool) {
+  // dogs are square cornered and bitches rounded, as in a pedigree chart
+  radius := 0
+  if node.Dog.Gender == "B" {
+    radius = svgNodeHeight / 2
+  } else if node.Dog.Gender != "D" {
+    radius = 6
+  }
+  strokeWidth := 1
+  if isDog {
+    strokeWidth = 3
+  }
+  dash := ""
+  if node.Mate {
+    dash = " stroke-dasharray=\"4 3\""
+  }
+  fmt.Fprintf(bw, "  <g>\n    <title>%s</title>\n",
+    html.EscapeString(fmt.Sprintf("%s: SLEM %s, CECS %s", node.Dog.Name, node.Dog.ShakingDogStatus, node.Dog.CecsStatus)))
+  fmt.Fprintf(bw, "    <rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" rx=\"%d\" fill=\"%s\" stroke=\"#212121\" stroke-width=\"%d\"%s/>\n",
+    pos.x, pos.y, nodeWidth, svgNodeHeight, radius, statusColour(node.Dog.ShakingDogStatus), strokeWidth, dash)
+  fmt.Fprintf(bw, "    <text x=\"%d\" y=\"%d\">%s</text>\n",
+    pos.x + 12, pos.y + svgNodeHeight / 2 + 4, html.EscapeString(node.Dog.Name))
+
+  // CECS badge at the right hand end
+  badgeX := pos.x + nodeWidth - svgBadgeWidth - 10
+  badgeY := pos.y + (svgNodeHeight - svgBadgeHeight) / 2
+  fmt.Fprintf(bw, "    <rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" rx=\"3\" fill=\"%s\" stroke=\"#212121\"/>\n",
+    badgeX, badgeY, svgBadgeWidth, svgBadgeHeight, statusColour(node.Dog.CecsStatus))
+  fmt.Fprintf(bw, "    <text x=\"%d\" y=\"%d\" font-size=\"9\" text-anchor=\"middle\">CECS %s</text>\n",
+    badgeX + svgBadgeWidth / 2, badgeY + svgBadgeHeight - 4, statusAbbreviation(node.Dog.CecsStatus))
+  fmt.Fprintln(bw, "  </g>")
+}
@@ -0,0 +1,120 @@
+package stream
+
+import (
+  "log"
+  "sync"
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+const (
+  // events fetched per poll
+  BatchSize = 100
+  // events held for a client before it's judged too slow and dropped,
+  // after which it reconnects and catches up from Last-Event-ID
+  ClientBuffer = 256
+)
+
+// fans out new register events to every connected client, from one
+// poll of the event table however many clients there are
+// NOTE: event IDs are in commit order, as every transaction that saves
+//       an audit entry holds the lock on the audit chain's head until it
+//       ends, so polling for IDs after the last one misses nothing
+type Broker struct {
+  DBConn *db.Connection
+  Interval time.Duration
+
+  lock sync.Mutex
+  clients map[chan data.Event]bool
+  lastId int
+}
+
+
+func NewBroker(dbConn *db.Connection) *Broker {
+  return &Broker{
+    DBConn: dbConn,
+    Interval: 2 * time.Second,
+    clients: map[chan data.Event]bool{},
+  }
+}
+
+func (b *Broker) Run(stop <-chan struct{}) {
+  // polls for new events until stopped, starting from the latest so
+  // that history is only sent to clients that ask for it
+  lastId, err := db.GetLastEventId(b.DBConn)
+  if err != nil {
+    log.Printf("ERROR: Broker: GetLastEventId error - %v", err)
+  }
+  b.lock.Lock()
+  b.lastId = lastId
+  b.lock.Unlock()
+  ticker := time.NewTicker(b.Interval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-stop:
+      return
+    case <-ticker.C:
+    }
+    err = b.poll()
+    if err != nil {
+      log.Printf("ERROR: Broker: poll error - %v", err)
+    }
+  }
+}
+
+func (b *Broker) poll() error {
+  for {
+    b.lock.Lock()
+    lastId := b.lastId
+    b.lock.Unlock()
+    events, err := db.GetEventsSince(b.DBConn, lastId, BatchSize)
+    if err != nil {
+      return err
+    }
+    for _, event := range events {
+      b.Publish(event)
+    }
+    if len(events) < BatchSize {
+      return nil
+    }
+  }
+}
+
+func (b *Broker) Publish(event data.Event) {
+  // sends an event to every client without waiting on any of them
+  b.lock.Lock()
+  defer b.lock.Unlock()
+  if event.Id > b.lastId {
+    b.lastId = event.Id
+  }
+  for client, _ := range b.clients {
+    select {
+    case client <- event:
+    default:
+      delete(b.clients, client)
+      close(client)
+    }
+  }
+}
+
+func (b *Broker) Subscribe() (chan data.Event, int) {
+  // adds a client, returning its channel and the last event it will
+  // not be sent, so that anything before can be caught up on first
+  client := make(chan data.Event, ClientBuffer)
+  b.lock.Lock()
+  defer b.lock.Unlock()
+  b.clients[client] = true
+  return client, b.lastId
+}
+
+func (b *Broker) Unsubscribe(client chan data.Event) {
+  b.lock.Lock()
+  defer b.lock.Unlock()
+  if b.clients[client] {
+    delete(b.clients, client)
+    close(client)
+  }
+}
@@ -0,0 +1,265 @@
+package webhook
+
+import (
+  "bytes"
+  "context"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "log"
+  "net"
+  "net/http"
+  "net/url"
+  "strconv"
+  "syscall"
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+const (
+  // deliveries claimed per poll
+  BatchSize = 20
+  // attempts before a delivery is given up on
+  MaxAttempts = 8
+  // delay before the first retry, doubled for each retry after
+  InitialBackoff = 30 * time.Second
+  MaxBackoff = 6 * time.Hour
+  // how long a claimed delivery is left before being claimed again,
+  // which must outlast every request of the batch it's claimed with, as
+  // they are made one after the other, with a margin for saving them
+  LeaseTime = BatchSize * RequestTimeout + time.Minute
+  RequestTimeout = 15 * time.Second
+
+  SignatureHeader = "X-Shakingdog-Signature"
+  EventHeader = "X-Shakingdog-Event"
+  DeliveryHeader = "X-Shakingdog-Delivery"
+)
+
+type Dispatcher struct {
+  DBConn *db.Connection
+  Client *http.Client
+  Interval time.Duration
+  // where deliveries are stored, replaceable for tests
+  Claim func(limit, leaseSeconds int) ([]data.DueDelivery, error)
+  GetEvent func(id int) (data.Event, error)
+  SaveAttempt func(id int, status string, attempts, retrySeconds, responseCode int, attemptError string) error
+}
+
+
+func NewDispatcher(dbConn *db.Connection) *Dispatcher {
+  d := &Dispatcher{
+    DBConn: dbConn,
+    Client: NewClient(),
+    Interval: 10 * time.Second,
+  }
+  d.Claim = d.claim
+  d.GetEvent = func(id int) (data.Event, error) {
+    return db.GetEvent(d.DBConn, id)
+  }
+  d.SaveAttempt = func(id int, status string, attempts, retrySeconds, responseCode int, attemptError string) error {
+    return db.SaveDeliveryAttempt(d.DBConn, id, status, attempts, retrySeconds, responseCode, attemptError)
+  }
+  return d
+}
+
+func NewClient() *http.Client {
+  // a client that won't connect to internal addresses, checked as each
+  // connection is made so that a receiver can't resolve to one later
+  dialer := &net.Dialer{
+    Timeout: RequestTimeout,
+    Control: func(network, address string, c syscall.RawConn) error {
+      host, _, err := net.SplitHostPort(address)
+      if err != nil {
+        return err
+      }
+      if !AllowedAddress(net.ParseIP(host)) {
+        return fmt.Errorf("address '%s' is not allowed", host)
+      }
+      return nil
+    },
+  }
+  transport := http.DefaultTransport.(*http.Transport).Clone()
+  transport.Proxy = nil
+  transport.DialContext = dialer.DialContext
+  return &http.Client{
+    Timeout: RequestTimeout,
+    Transport: transport,
+    // redirects could lead anywhere, so are treated as failures
+    CheckRedirect: func(req *http.Request, via []*http.Request) error {
+      return http.ErrUseLastResponse
+    },
+  }
+}
+
+func AllowedAddress(ip net.IP) bool {
+  // whether a receiver may be at the address, which rules out loopback,
+  // private, link-local (including cloud metadata) and other internal
+  // addresses
+  return ip != nil &&
+    !ip.IsLoopback() &&
+    !ip.IsPrivate() &&
+    !ip.IsLinkLocalUnicast() &&
+    !ip.IsLinkLocalMulticast() &&
+    !ip.IsInterfaceLocalMulticast() &&
+    !ip.IsMulticast() &&
+    !ip.IsUnspecified()
+}
+
+func CheckUrl(ctx context.Context, rawUrl string) error {
+  // checks a receiver URL is http(s) and that its host only resolves to
+  // allowed addresses
+  target, err := url.Parse(rawUrl)
+  if err != nil {
+    return err
+  }
+  if (target.Scheme != "http" && target.Scheme != "https") || len(target.Hostname()) == 0 {
+    return fmt.Errorf("url must be http(s) with a host")
+  }
+  addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target.Hostname())
+  if err != nil {
+    return err
+  }
+  for _, addr := range addrs {
+    if !AllowedAddress(addr.IP) {
+      return fmt.Errorf("address '%s' is not allowed", addr.IP)
+    }
+  }
+  return nil
+}
+
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+  // polls for due deliveries until stopped
+  ticker := time.NewTicker(d.Interval)
+  defer ticker.Stop()
+  for {
+    _, err := d.DeliverDue()
+    if err != nil {
+      log.Printf("ERROR: Dispatcher: DeliverDue error - %v", err)
+    }
+    select {
+    case <-stop:
+      return
+    case <-ticker.C:
+    }
+  }
+}
+
+func (d *Dispatcher) DeliverDue() (int, error) {
+  // attempts every delivery that is due, returning how many were made
+  attempted := 0
+  for {
+    due, err := d.Claim(BatchSize, int(LeaseTime / time.Second))
+    if err != nil {
+      return attempted, err
+    }
+    for _, delivery := range due {
+      err = d.attempt(&delivery)
+      if err != nil {
+        return attempted, err
+      }
+      attempted++
+    }
+    if len(due) < BatchSize {
+      return attempted, nil
+    }
+  }
+}
+
+func (d *Dispatcher) claim(limit, leaseSeconds int) ([]data.DueDelivery, error) {
+  txConn, err := d.DBConn.BeginReadUncommitted(nil)
+  if err != nil {
+    return nil, err
+  }
+  defer txConn.Rollback()
+  due, err := db.ClaimDueDeliveries(txConn, limit, leaseSeconds)
+  if err != nil {
+    return nil, err
+  }
+  return due, txConn.Commit()
+}
+
+func (d *Dispatcher) attempt(delivery *data.DueDelivery) error {
+  // posts the event and records the outcome, where anything other than
+  // a 2xx response is retried after a backoff
+  event, err := d.GetEvent(delivery.EventId)
+  if err != nil {
+    return err
+  }
+  body, err := json.Marshal(event)
+  if err != nil {
+    return err
+  }
+  responseCode, postErr := d.post(delivery, &event, body)
+
+  // work out what's next
+  attempts := delivery.Attempts + 1
+  status := db.DeliveryDelivered
+  attemptError := ""
+  if postErr != nil {
+    attemptError = postErr.Error()
+  } else if responseCode < 200 || responseCode > 299 {
+    attemptError = fmt.Sprintf("Unexpected response '%d %s'", responseCode, http.StatusText(responseCode))
+  }
+  if len(attemptError) > 0 {
+    status = db.DeliveryPending
+    if attempts >= MaxAttempts {
+      status = db.DeliveryFailed
+    }
+    log.Printf("INFO: Dispatcher: Delivery %d attempt %d failed - %s", delivery.Id, attempts, attemptError)
+  }
+  return d.SaveAttempt(
+    delivery.Id,
+    status,
+    attempts,
+    int(Backoff(attempts) / time.Second),
+    responseCode,
+    attemptError,
+  )
+}
+
+func (d *Dispatcher) post(delivery *data.DueDelivery, event *data.Event, body []byte) (int, error) {
+  req, err := http.NewRequest("POST", delivery.Url, bytes.NewReader(body))
+  if err != nil {
+    return 0, err
+  }
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("User-Agent", "shakingdog-webhook")
+  req.Header.Set(EventHeader, event.Type)
+  req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.Id))
+  req.Header.Set(SignatureHeader, Sign(delivery.Secret, body))
+  resp, err := d.Client.Do(req)
+  if err != nil {
+    return 0, err
+  }
+  defer resp.Body.Close()
+
+  // drain (some of) the body so the connection can be reused
+  io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64 * 1024))
+  return resp.StatusCode, nil
+}
+
+func Sign(secret string, body []byte) string {
+  // HMAC-SHA256 of the body, which receivers recompute with their
+  // copy of the secret to check a delivery is genuine
+  mac := hmac.New(sha256.New, []byte(secret))
+  mac.Write(body)
+  return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Backoff(attempts int) time.Duration {
+  // delay before the next attempt, after the given number of attempts
+  backoff := InitialBackoff
+  for i := 1; i < attempts && backoff < MaxBackoff; i++ {
+    backoff *= 2
+  }
+  if backoff > MaxBackoff {
+    return MaxBackoff
+  }
+  return backoff
+}
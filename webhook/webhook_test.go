@@ -0,0 +1,341 @@
+package webhook
+
+import (
+  "context"
+  "encoding/json"
+  "io/ioutil"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "sort"
+  "sync"
+  "testing"
+  "time"
+
+  "bitbucket.org/Rusty1958/shakingdog/data"
+  "bitbucket.org/Rusty1958/shakingdog/db"
+)
+
+// deliveries kept in memory, claimed and saved the same way as by
+// db.ClaimDueDeliveries and db.SaveDeliveryAttempt, against a clock
+// that only moves when told to
+type testDelivery struct {
+  data.DueDelivery
+  status string
+  nextAttempt time.Time
+  responseCode int
+}
+
+type testStore struct {
+  lock sync.Mutex
+  now time.Time
+  deliveries map[int]*testDelivery
+}
+
+func newTestStore(url, secret string, count int) *testStore {
+  store := &testStore{
+    now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+    deliveries: map[int]*testDelivery{},
+  }
+  for i := 1; i <= count; i++ {
+    store.deliveries[i] = &testDelivery{
+      DueDelivery: data.DueDelivery{Id: i, EventId: 100 + i, Url: url, Secret: secret},
+      status: db.DeliveryPending,
+      nextAttempt: store.now,
+    }
+  }
+  return store
+}
+
+func (s *testStore) Claim(limit, leaseSeconds int) ([]data.DueDelivery, error) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  ids := []int{}
+  for id, delivery := range s.deliveries {
+    if delivery.status == db.DeliveryPending && !delivery.nextAttempt.After(s.now) {
+      ids = append(ids, id)
+    }
+  }
+  sort.Ints(ids)
+  due := []data.DueDelivery{}
+  for _, id := range ids {
+    if len(due) == limit {
+      break
+    }
+    s.deliveries[id].nextAttempt = s.now.Add(time.Duration(leaseSeconds) * time.Second)
+    due = append(due, s.deliveries[id].DueDelivery)
+  }
+  return due, nil
+}
+
+func (s *testStore) GetEvent(id int) (data.Event, error) {
+  return data.Event{Id: id, Type: "dog.updated", Stamp: "2020-01-01 00:00:00"}, nil
+}
+
+func (s *testStore) SaveAttempt(id int, status string, attempts, retrySeconds, responseCode int, attemptError string) error {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  delivery := s.deliveries[id]
+  if delivery.status != db.DeliveryPending {
+    return nil
+  }
+  delivery.status = status
+  delivery.Attempts = attempts
+  delivery.responseCode = responseCode
+  if status == db.DeliveryPending {
+    delivery.nextAttempt = s.now.Add(time.Duration(retrySeconds) * time.Second)
+  }
+  return nil
+}
+
+func (s *testStore) Advance(by time.Duration) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  s.now = s.now.Add(by)
+}
+
+func (s *testStore) Delivery(id int) testDelivery {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  return *s.deliveries[id]
+}
+
+func newTestDispatcher(store *testStore, client *http.Client) *Dispatcher {
+  return &Dispatcher{
+    Client: client,
+    Interval: time.Second,
+    Claim: store.Claim,
+    GetEvent: store.GetEvent,
+    SaveAttempt: store.SaveAttempt,
+  }
+}
+
+// receiver answers with each status in turn, repeating the last
+type receiver struct {
+  lock sync.Mutex
+  statuses []int
+  requests []*http.Request
+  bodies [][]byte
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+  body, _ := ioutil.ReadAll(req.Body)
+  rcv.lock.Lock()
+  rcv.requests = append(rcv.requests, req)
+  rcv.bodies = append(rcv.bodies, body)
+  status := rcv.statuses[0]
+  if len(rcv.statuses) > 1 {
+    rcv.statuses = rcv.statuses[1:]
+  }
+  rcv.lock.Unlock()
+  w.WriteHeader(status)
+}
+
+func (rcv *receiver) Count() int {
+  rcv.lock.Lock()
+  defer rcv.lock.Unlock()
+  return len(rcv.requests)
+}
+
+func TestSignedDelivery(t *testing.T) {
+  rcv := &receiver{statuses: []int{http.StatusNoContent}}
+  srv := httptest.NewServer(rcv)
+  defer srv.Close()
+  store := newTestStore(srv.URL, "s3cret", 1)
+  d := newTestDispatcher(store, srv.Client())
+
+  attempted, err := d.DeliverDue()
+  if err != nil || attempted != 1 {
+    t.Fatalf("DeliverDue = %d, %v", attempted, err)
+  }
+  req, body := rcv.requests[0], rcv.bodies[0]
+  if req.Header.Get(SignatureHeader) != Sign("s3cret", body) {
+    t.Errorf("signature %q does not match body", req.Header.Get(SignatureHeader))
+  }
+  if Sign("other", body) == Sign("s3cret", body) {
+    t.Errorf("signature does not depend on the secret")
+  }
+  if req.Header.Get(EventHeader) != "dog.updated" || req.Header.Get(DeliveryHeader) != "1" {
+    t.Errorf("headers = %q, %q", req.Header.Get(EventHeader), req.Header.Get(DeliveryHeader))
+  }
+  var event data.Event
+  if err = json.Unmarshal(body, &event); err != nil || event.Id != 101 {
+    t.Errorf("body = %s", body)
+  }
+  delivery := store.Delivery(1)
+  if delivery.status != db.DeliveryDelivered || delivery.Attempts != 1 || delivery.responseCode != http.StatusNoContent {
+    t.Errorf("delivery = %+v", delivery)
+  }
+}
+
+func TestRetryAfterServerError(t *testing.T) {
+  rcv := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusOK}}
+  srv := httptest.NewServer(rcv)
+  defer srv.Close()
+  store := newTestStore(srv.URL, "s3cret", 1)
+  d := newTestDispatcher(store, srv.Client())
+
+  d.DeliverDue()
+  delivery := store.Delivery(1)
+  if delivery.status != db.DeliveryPending || delivery.Attempts != 1 || delivery.responseCode != http.StatusInternalServerError {
+    t.Fatalf("delivery after 5xx = %+v", delivery)
+  }
+
+  // not retried until the first backoff has passed
+  store.Advance(Backoff(1) - time.Second)
+  if attempted, _ := d.DeliverDue(); attempted != 0 {
+    t.Errorf("retried %d before backoff", attempted)
+  }
+  store.Advance(time.Second)
+  if attempted, _ := d.DeliverDue(); attempted != 1 {
+    t.Errorf("retried %d after backoff, want 1", attempted)
+  }
+  delivery = store.Delivery(1)
+  if delivery.status != db.DeliveryDelivered || delivery.Attempts != 2 {
+    t.Errorf("delivery after retry = %+v", delivery)
+  }
+  if rcv.Count() != 2 {
+    t.Errorf("receiver got %d requests, want 2", rcv.Count())
+  }
+}
+
+func TestFailedAfterMaxAttempts(t *testing.T) {
+  rcv := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+  srv := httptest.NewServer(rcv)
+  defer srv.Close()
+  store := newTestStore(srv.URL, "s3cret", 1)
+  d := newTestDispatcher(store, srv.Client())
+
+  for attempts := 1; attempts <= MaxAttempts; attempts++ {
+    d.DeliverDue()
+    delivery := store.Delivery(1)
+    if delivery.Attempts != attempts {
+      t.Fatalf("attempts = %d, want %d", delivery.Attempts, attempts)
+    }
+    if attempts < MaxAttempts {
+      if delivery.status != db.DeliveryPending {
+        t.Fatalf("status after %d attempts = %s", attempts, delivery.status)
+      }
+      if wait := delivery.nextAttempt.Sub(store.now); wait != Backoff(attempts) {
+        t.Errorf("wait after %d attempts = %v, want %v", attempts, wait, Backoff(attempts))
+      }
+    }
+    store.Advance(Backoff(attempts))
+  }
+  if delivery := store.Delivery(1); delivery.status != db.DeliveryFailed {
+    t.Fatalf("status after %d attempts = %s", MaxAttempts, delivery.status)
+  }
+  store.Advance(MaxBackoff)
+  if attempted, _ := d.DeliverDue(); attempted != 0 {
+    t.Errorf("failed delivery attempted again")
+  }
+  if rcv.Count() != MaxAttempts {
+    t.Errorf("receiver got %d requests, want %d", rcv.Count(), MaxAttempts)
+  }
+}
+
+func TestBackoff(t *testing.T) {
+  expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+  for i, backoff := range expected {
+    if Backoff(i + 1) != backoff {
+      t.Errorf("Backoff(%d) = %v, want %v", i + 1, Backoff(i + 1), backoff)
+    }
+  }
+  if Backoff(100) != MaxBackoff {
+    t.Errorf("Backoff(100) = %v, want %v", Backoff(100), MaxBackoff)
+  }
+}
+
+func TestLeasePreventsDoubleDelivery(t *testing.T) {
+  if LeaseTime <= BatchSize * RequestTimeout {
+    t.Fatalf("lease %v does not outlast a batch of %d requests of %v", LeaseTime, BatchSize, RequestTimeout)
+  }
+  seen := map[string]int{}
+  var lock sync.Mutex
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    time.Sleep(5 * time.Millisecond)
+    lock.Lock()
+    seen[req.Header.Get(DeliveryHeader)]++
+    lock.Unlock()
+  }))
+  defer srv.Close()
+  store := newTestStore(srv.URL, "s3cret", 3 * BatchSize)
+
+  // claimed deliveries aren't claimed again while leased...
+  d := newTestDispatcher(store, srv.Client())
+  claimed, _ := d.Claim(BatchSize, int(LeaseTime / time.Second))
+  if again, _ := d.Claim(3 * BatchSize, int(LeaseTime / time.Second)); len(again) != 2 * BatchSize {
+    t.Errorf("claimed %d while leased, want %d", len(again), 2 * BatchSize)
+  }
+
+  // ...but are once the lease runs out, as after a crash
+  store.Advance(LeaseTime)
+  if again, _ := d.Claim(3 * BatchSize, int(LeaseTime / time.Second)); len(again) != 3 * BatchSize {
+    t.Errorf("claimed %d after lease, want %d", len(again), 3 * BatchSize)
+  }
+  store.Advance(LeaseTime)
+
+  // dispatchers running side by side deliver each once between them
+  var wait sync.WaitGroup
+  total := 0
+  for i := 0; i < 3; i++ {
+    wait.Add(1)
+    go func() {
+      defer wait.Done()
+      attempted, err := newTestDispatcher(store, srv.Client()).DeliverDue()
+      if err != nil {
+        t.Errorf("DeliverDue error - %v", err)
+      }
+      lock.Lock()
+      total += attempted
+      lock.Unlock()
+    }()
+  }
+  wait.Wait()
+  if total != 3 * BatchSize || len(seen) != 3 * BatchSize {
+    t.Errorf("attempted %d, received %d distinct, want %d", total, len(seen), 3 * BatchSize)
+  }
+  for id, count := range seen {
+    if count != 1 {
+      t.Errorf("delivery %s received %d times", id, count)
+    }
+  }
+  if len(claimed) != BatchSize {
+    t.Errorf("claimed %d, want %d", len(claimed), BatchSize)
+  }
+}
+
+func TestInternalAddressesRefused(t *testing.T) {
+  for _, address := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0", "::ffff:127.0.0.1"} {
+    if AllowedAddress(net.ParseIP(address)) {
+      t.Errorf("%s allowed", address)
+    }
+  }
+  for _, address := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"} {
+    if !AllowedAddress(net.ParseIP(address)) {
+      t.Errorf("%s refused", address)
+    }
+  }
+  for _, rawUrl := range []string{"http://127.0.0.1:8080/hook", "https://[::1]/hook", "http://169.254.169.254/latest/meta-data", "http://localhost/hook", "ftp://93.184.216.34/hook", "/hook"} {
+    if CheckUrl(context.Background(), rawUrl) == nil {
+      t.Errorf("%s allowed", rawUrl)
+    }
+  }
+  if err := CheckUrl(context.Background(), "https://93.184.216.34/hook"); err != nil {
+    t.Errorf("public address refused - %v", err)
+  }
+
+  // the delivery client refuses them when connecting too, whatever the
+  // receiver's host resolved to when it was registered
+  rcv := &receiver{statuses: []int{http.StatusOK}}
+  srv := httptest.NewServer(rcv)
+  defer srv.Close()
+  store := newTestStore(srv.URL, "s3cret", 1)
+  newTestDispatcher(store, NewClient()).DeliverDue()
+  if rcv.Count() != 0 {
+    t.Errorf("loopback receiver was posted to")
+  }
+  if delivery := store.Delivery(1); delivery.status != db.DeliveryPending || delivery.Attempts != 1 {
+    t.Errorf("delivery = %+v", delivery)
+  }
+}